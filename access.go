@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"strings"
 
 	u "github.com/iavael/goutil"
@@ -17,7 +18,7 @@ const (
 )
 
 type hostInterface interface {
-	inNetGroups([]string) bool
+	inNetGroups(context.Context, Directory, string, []string) bool
 	matchACL(string) bool
 }
 
@@ -37,7 +38,8 @@ func (h Host) matchACL(acl string) bool {
 	return result
 }
 
-func checkAccess(user string, host hostInterface, entries []*ldap.Entry) bool {
+// checkAccess returns true and grant reason if any of entries of dir permits user to access host
+func checkAccess(ctx context.Context, dir Directory, user string, host hostInterface, entries []*ldap.Entry) (bool, string) {
 
 	debugLog("Checking ACLs and getting trustModel")
 	var (
@@ -69,24 +71,24 @@ func checkAccess(user string, host hostInterface, entries []*ldap.Entry) bool {
 
 		if tmodel == tmDeny {
 			logger.Info("User %s has 'deny' trustmodel", user)
-			return false
+			return false, ""
 		}
 		if tmodel == tmFull {
 			logger.Info("Granting access to user %s by trustmodel \"FullAccess\"", user)
-			return true
+			return true, strCat("trustModel FullAccess of ", entry.DN)
 		}
 
 		if tmodel == tmHost {
-			if checkByHost(user, entry, host) {
-				return true
+			if ok, reason := checkByHost(ctx, dir, user, entry, host); ok {
+				return true, reason
 			}
 		}
 	}
 	debugLog("Access denied: Unknown trustModel")
-	return false
+	return false, ""
 }
 
-func checkByHost(user string, entry *ldap.Entry, host hostInterface) bool {
+func checkByHost(ctx context.Context, dir Directory, user string, entry *ldap.Entry, host hostInterface) (bool, string) {
 	var netgroups []string
 	debugLog("Checking ACL")
 	for _, acl := range entry.GetAttributeValues("accessTo") {
@@ -94,15 +96,15 @@ func checkByHost(user string, entry *ldap.Entry, host hostInterface) bool {
 			netgroups = append(netgroups, acl[1:])
 		} else if host.matchACL(acl) {
 			logger.Info("Granting access to user %s by trustmodel \"ByHost\"", user)
-			return true
+			return true, strCat("host ", acl, " in accessTo of ", entry.DN)
 		}
 	}
 
 	debugLog("Host was not found in ACL")
-	if host.inNetGroups(netgroups) {
+	if host.inNetGroups(ctx, dir, user, netgroups) {
 		logger.Info("Granting access to user %s by trustmodel \"ByHost\"", user)
-		return true
+		return true, strCat("netgroup in accessTo of ", entry.DN)
 	}
 	debugLog("Host not found in netgroups, access denied")
-	return false
+	return false, ""
 }
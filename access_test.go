@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 
 	u "github.com/iavael/goutil"
@@ -12,7 +13,7 @@ type HostTest struct {
 	hostname string
 }
 
-func (ht HostTest) inNetGroups(_ []string) bool {
+func (ht HostTest) inNetGroups(_ context.Context, _ Directory, _ string, _ []string) bool {
 	return false
 }
 
@@ -31,25 +32,28 @@ func TestAccess(t *testing.T) {
 	test = ldap.NewEntry("cn=test", map[string][]string{
 		"trustModel": {"fullAccess"},
 	})
-	assert.True(checkAccess("user", HostTest{"example.com"}, []*ldap.Entry{test}))
+	assert.True(checkAccess(context.Background(), nil, "user", HostTest{"example.com"}, []*ldap.Entry{test}))
 
 	test = ldap.NewEntry("cn=test", map[string][]string{
 		"trustModel": {"byHost"},
 	})
-	assert.False(checkAccess("user", HostTest{"example.com"}, []*ldap.Entry{test}))
+	assert.False(checkAccess(context.Background(), nil, "user", HostTest{"example.com"}, []*ldap.Entry{test}))
 
 	test = ldap.NewEntry("cn=test", map[string][]string{})
-	assert.False(checkAccess("user", HostTest{"example.com"}, []*ldap.Entry{test}))
+	assert.False(checkAccess(context.Background(), nil, "user", HostTest{"example.com"}, []*ldap.Entry{test}))
 
 	test = ldap.NewEntry("cn=test", map[string][]string{
 		"trustModel": {"byHost"},
 		"accessTo":   {"example.com"},
 	})
-	assert.True(checkAccess("user", HostTest{"example.com"}, []*ldap.Entry{test}))
+	assert.True(checkAccess(context.Background(), nil, "user", HostTest{"example.com"}, []*ldap.Entry{test}))
+
+	_, reason := checkAccess(context.Background(), nil, "user", HostTest{"example.com"}, []*ldap.Entry{test})
+	assert.Equal("host example.com in accessTo of cn=test", reason)
 
 	test = ldap.NewEntry("cn=test", map[string][]string{
 		"trustModel": {"byHost"},
 		"accessTo":   {"example.net"},
 	})
-	assert.False(checkAccess("user", HostTest{"example.com"}, []*ldap.Entry{test}))
+	assert.False(checkAccess(context.Background(), nil, "user", HostTest{"example.com"}, []*ldap.Entry{test}))
 }
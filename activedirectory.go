@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	schemaAD = "ad"
+
+	// adInChain is LDAP_MATCHING_RULE_IN_CHAIN, it walks nested group membership on server side
+	adInChain = "1.2.840.113556.1.4.1941"
+	// adKeyPrefix marks SSH keys among other mappings in altSecurityIdentities
+	adKeyPrefix = "SSHKey:"
+
+	// Lockout is never stored in userAccountControl, AD computes it into adUACComputed
+	adUACComputed     = "msDS-User-Account-Control-Computed"
+	uacAccountDisable = 0x0002
+	uacLockout        = 0x0010
+)
+
+// ActiveDirectory describes where AD keeps SSH keys of users
+type ActiveDirectory struct {
+	KeyAttr string `yaml:"key_attribute"`
+}
+
+// adDirectory reads AD users and groups, access is still decided by trustModel and accessTo
+type adDirectory struct {
+	ldapDirectory
+}
+
+func (adDirectory) String() string {
+	return schemaAD
+}
+
+// findUsers returns enabled and unlocked users with sAMAccountName or userPrincipalName user,
+// their keys are exposed as sshPublicKey
+func (d adDirectory) findUsers(ctx context.Context, user string, hosts []string, attrs []string) ([]*ldap.Entry, error) {
+	var (
+		keyAttr  = d.cfg.GetActiveDirectory().KeyAttr
+		adAttrs  = []string{"userAccountControl", adUACComputed}
+		aclFltr  string
+		accounts []*ldap.Entry
+	)
+	for _, attr := range attrs {
+		if attr == "sshPublicKey" {
+			attr = keyAttr
+		}
+		adAttrs = append(adAttrs, attr)
+	}
+	if hosts != nil {
+		aclFltr = aclFilter(hosts)
+	}
+	entries, err := d.search(ctx, d.cfg.GetLdapUsers(), strCat("(&", adUserFilter(user), aclFltr, ")"), adAttrs)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if len(entry.GetAttributeValue(adUACComputed)) == 0 {
+			// Some constructed attributes are returned by base searches only
+			if computed, err := d.searchBase(ctx, entry.DN, []string{adUACComputed}); err != nil {
+				return nil, err
+			} else if computed != nil {
+				entry.Attributes = append(entry.Attributes, computed.Attributes...)
+			}
+		}
+		switch disabled, err := adFlag(entry, "userAccountControl", uacAccountDisable); {
+		case err != nil:
+			logger.Warn("%s, skipping", err)
+			continue
+		case disabled:
+			logger.Warn("Account %s is disabled, skipping", entry.DN)
+			continue
+		}
+		switch locked, err := adFlag(entry, adUACComputed, uacLockout); {
+		case err != nil:
+			logger.Warn("%s, skipping", err)
+			continue
+		case locked:
+			logger.Warn("Account %s is locked, skipping", entry.DN)
+			continue
+		}
+		for _, attr := range entry.Attributes {
+			if strings.EqualFold(attr.Name, keyAttr) {
+				attr.Name = "sshPublicKey"
+				attr.Values = adKeys(keyAttr, attr.Values)
+			}
+		}
+		accounts = append(accounts, entry)
+	}
+	return accounts, nil
+}
+
+// adFlag checks flag in bit mask attribute of entry, missing or invalid attribute is error
+func adFlag(entry *ldap.Entry, attr string, flag uint64) (bool, error) {
+	value := entry.GetAttributeValue(attr)
+	if len(value) == 0 {
+		return false, fmt.Errorf("No %s in DN %s", attr, entry.DN)
+	}
+	flags, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return false, fmt.Errorf("Invalid %s %s in DN %s", attr, value, entry.DN)
+	}
+	return flags&flag != 0, nil
+}
+
+// findGroups returns groups user is member of directly or through nested groups
+func (d adDirectory) findGroups(ctx context.Context, user string, hosts []string, attrs []string) ([]*ldap.Entry, error) {
+	users, err := d.search(ctx, d.cfg.GetLdapUsers(), adUserFilter(user), []string{"1.1"})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) != 1 {
+		debugLog("Found %d AD users with name %s", len(users), user)
+		return nil, nil
+	}
+	var aclFltr string
+	if hosts != nil {
+		aclFltr = aclFilter(hosts)
+	}
+	return d.search(ctx, d.cfg.GetLdapGroups(),
+		strCat("(&(objectClass=group)(member:", adInChain, ":=", ldap.EscapeFilter(users[0].DN), ")", aclFltr, ")"), attrs)
+}
+
+func adUserFilter(user string) string {
+	user = ldap.EscapeFilter(user)
+	return strCat("(&(objectCategory=person)(objectClass=user)(|(sAMAccountName=", user, ")(userPrincipalName=", user, ")))")
+}
+
+func (d adDirectory) search(ctx context.Context, base, filter string, attrs []string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		base,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attrs,
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+func (d adDirectory) searchBase(ctx context.Context, dn string, attrs []string) (*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		dn,
+		ldap.ScopeBaseObject, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=*)",
+		attrs,
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, req)
+	if err != nil || len(sr.Entries) == 0 {
+		return nil, err
+	}
+	return sr.Entries[0], nil
+}
+
+// adKeys returns SSH keys from altSecurityIdentities without prefix, values of custom attribute are kept as is
+func adKeys(attr string, values []string) (keys []string) {
+	if !strings.EqualFold(attr, "altSecurityIdentities") {
+		return values
+	}
+	for _, value := range values {
+		if len(value) > len(adKeyPrefix) && strings.EqualFold(value[:len(adKeyPrefix)], adKeyPrefix) {
+			keys = append(keys, strings.TrimSpace(value[len(adKeyPrefix):]))
+		}
+	}
+	return
+}
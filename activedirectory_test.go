@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+const adTestKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ alice@laptop"
+
+func TestActiveDirectory(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		ctx    = context.Background()
+		uac    = "512"
+		// computed is msDS-User-Account-Control-Computed of subtree and base searches
+		computed = [2]string{"0", ""}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapServers = []string{"dc1.example.com:389"}
+	cfg.LdapBind = "cn=keyreader,cn=users,dc=example,dc=com"
+	cfg.LdapPass = "secret"
+	cfg.LdapUsers = "ou=people,dc=example,dc=com"
+	cfg.LdapGroups = "ou=groups,dc=example,dc=com"
+	cfg.LdapNetGrs = "ou=netgroups,dc=example,dc=com"
+	cfg.LdapSchema = schemaAD
+	assert.Error(cfg.Check())
+	cfg.ActiveDirectory.KeyAttr = "altSecurityIdentities"
+	assert.NoError(cfg.Check())
+
+	conn := &ClientTest{}
+	dir, err := newDirectory(cfg, conn)
+	assert.NoError(err)
+	assert.Equal(schemaAD, dir.String())
+
+	const (
+		aliceFilter = "(&(objectCategory=person)(objectClass=user)(|(sAMAccountName=alice)(userPrincipalName=alice)))"
+		aliceDN     = "CN=Alice (Ops),OU=People,DC=example,DC=com"
+	)
+	conn.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		switch req.BaseDN {
+		case cfg.LdapUsers:
+			switch req.Filter {
+			case aliceFilter:
+				assert.Equal([]string{"1.1"}, req.Attributes)
+				return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(aliceDN, nil)}}, nil
+			case "(&" + aliceFilter + ")":
+				assert.Contains(req.Attributes, cfg.ActiveDirectory.KeyAttr)
+				assert.Contains(req.Attributes, "userAccountControl")
+				assert.Contains(req.Attributes, adUACComputed)
+				attrs := map[string][]string{
+					"altSecurityIdentities": {"X509:<I>DC=com,DC=example,CN=CA<S>CN=alice", "SSHKey:" + adTestKey},
+				}
+				if len(uac) != 0 {
+					attrs["userAccountControl"] = []string{uac}
+				}
+				if len(computed[0]) != 0 {
+					attrs[adUACComputed] = []string{computed[0]}
+				}
+				return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(aliceDN, attrs)}}, nil
+			}
+		case aliceDN:
+			assert.Equal(ldap.ScopeBaseObject, req.Scope)
+			assert.Equal([]string{adUACComputed}, req.Attributes)
+			if len(computed[1]) == 0 {
+				return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(aliceDN, nil)}}, nil
+			}
+			return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(aliceDN, map[string][]string{
+				adUACComputed: {computed[1]},
+			})}}, nil
+		case cfg.LdapGroups:
+			assert.Equal("(&(objectClass=group)(member:1.2.840.113556.1.4.1941:=CN=Alice \\28Ops\\29,OU=People,DC=example,DC=com)"+
+				"(|(trustmodel=fullaccess)(accessTo=+*)(accessTo=web1.example.com)))", req.Filter)
+			return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("CN=Admins,OU=Groups,DC=example,DC=com",
+				map[string][]string{"cn": {"Admins"}, "trustModel": {"fullaccess"}})}}, nil
+		}
+		return &ldap.SearchResult{}, nil
+	}
+
+	web1 := &Host{names: []string{"web1.example.com"}}
+	keys := userKeys(ctx, dir, "alice", web1)
+	if assert.Len(keys, 1) {
+		assert.Equal(adTestKey, keys[0].line)
+	}
+	assert.Empty(userKeys(ctx, dir, "bob", web1))
+
+	// Disabled accounts and accounts without readable userAccountControl are skipped
+	for _, flags := range []string{"514", "bogus", ""} {
+		uac = flags
+		assert.Empty(userKeys(ctx, dir, "alice", web1), flags)
+	}
+
+	// Lockout is taken from computed attribute only, base search is used if subtree one doesn't return it
+	uac = "528"
+	assert.Len(userKeys(ctx, dir, "alice", web1), 1)
+	for _, state := range [][2]string{{"16", ""}, {"", "16"}, {"", ""}, {"bogus", ""}} {
+		computed = state
+		assert.Empty(userKeys(ctx, dir, "alice", web1), state)
+	}
+	computed = [2]string{"", "0"}
+	assert.Len(userKeys(ctx, dir, "alice", web1), 1)
+
+	uac = "512"
+	cfg.ActiveDirectory.KeyAttr = "sshKeys"
+	users, err := dir.findUsers(ctx, "alice", nil, []string{"sshPublicKey"})
+	assert.NoError(err)
+	if assert.Len(users, 1) {
+		assert.Empty(users[0].GetAttributeValues("sshPublicKey"))
+	}
+}
+
+func TestADKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"ssh-rsa AAAA", "ssh-ed25519 BBBB"},
+		adKeys("altsecurityidentities", []string{"sshkey:ssh-rsa AAAA", "Kerberos:alice@EXAMPLE.COM", "SSHKey: ssh-ed25519 BBBB", "SSHKey:"}))
+	assert.Equal([]string{"SSHKey:ssh-rsa AAAA"}, adKeys("sshKeys", []string{"SSHKey:ssh-rsa AAAA"}))
+}
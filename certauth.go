@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+// CertAuthority describes where to find SSH certificate authorities trusted for users
+type CertAuthority struct {
+	Enabled        bool   `yaml:"enabled"`
+	UserAttr       string `yaml:"user_attr"`
+	Base           string `yaml:"base"`
+	Filter         string `yaml:"filter"`
+	KeyAttr        string `yaml:"key_attr"`
+	PrincipalsAttr string `yaml:"principals_attr"`
+}
+
+// Check function validates certificate authority settings
+func (c *CertAuthority) Check() error {
+	if !c.Enabled {
+		return nil
+	}
+	switch {
+	case len(c.UserAttr) == 0 && len(c.Base) == 0:
+		return errors.New("No user attribute or base for certificate authorities defined")
+	case len(c.Base) != 0 && len(c.KeyAttr) == 0:
+		return errors.New("No key attribute for certificate authority entries defined")
+	case len(c.Base) != 0 && len(c.Filter) == 0:
+		return errors.New("No filter for certificate authority entries defined")
+	}
+	if len(c.Filter) != 0 {
+		if _, err := ldap.CompileFilter(c.Filter); err != nil {
+			return errors.New(strCat("Invalid certificate authority filter: ", err.Error()))
+		}
+	}
+	return nil
+}
+
+// userAttrs returns attributes of user entry needed to build cert-authority lines
+func (c *CertAuthority) userAttrs() (attrs []string) {
+	if !c.Enabled {
+		return nil
+	}
+	for _, attr := range []string{"uid", c.UserAttr, c.PrincipalsAttr} {
+		if len(attr) != 0 {
+			attrs = append(attrs, attr)
+		}
+	}
+	return
+}
+
+// caKeys returns cert-authority lines restricted to user's principals
+func (c *CertAuthority) caKeys(ctx context.Context, src *ldapSource, entry *ldap.Entry, options []string) (keys []userKey) {
+	principals := c.principals(entry)
+	if len(principals) == 0 {
+		logger.Warn("No valid principals for DN %s, skipping certificate authorities", entry.DN)
+		return nil
+	}
+	caOptions := append([]string{
+		"cert-authority",
+		strCat(`principals="`, strings.Join(principals, ","), `"`),
+	}, options...)
+
+	if len(c.UserAttr) != 0 {
+		keys = append(keys, newUserKeys(entry.DN, entry.GetAttributeValues(c.UserAttr), caOptions)...)
+	}
+
+	if len(c.Base) != 0 {
+		caReq := ldap.NewSearchRequest(
+			c.Base,
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			c.Filter,
+			[]string{c.KeyAttr},
+			nil,
+		)
+		sr, err := src.ldapSearch(ctx, caReq)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(26)
+		}
+		for _, ca := range sr.Entries {
+			keys = append(keys, newUserKeys(ca.DN, ca.GetAttributeValues(c.KeyAttr), caOptions)...)
+		}
+	}
+	return
+}
+
+// principals returns user's login and extra principals from principals attribute
+func (c *CertAuthority) principals(entry *ldap.Entry) (res []string) {
+	values := entry.GetAttributeValues("uid")
+	if len(c.PrincipalsAttr) != 0 {
+		values = append(values, entry.GetAttributeValues(c.PrincipalsAttr)...)
+	}
+	seen := map[string]bool{}
+	for _, principal := range values {
+		if len(principal) == 0 || strings.ContainsAny(principal, "\",\\ \t\n") {
+			logger.Warn("Invalid principal %q in DN %s", principal, entry.DN)
+			continue
+		}
+		if !seen[principal] {
+			seen[principal] = true
+			res = append(res, principal)
+		}
+	}
+	return
+}
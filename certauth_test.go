@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/ldap.v2"
+)
+
+func TestCertAuthority(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		cas    []string
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+
+	for i := 0; i < 2; i++ {
+		pub, _, _ := ed25519.GenerateKey(rand.Reader)
+		key, _ := ssh.NewPublicKey(pub)
+		cas = append(cas, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+	}
+
+	conn := &ClientTest{}
+	dir, err := newDirectory(cfg, conn)
+	assert.NoError(err)
+	conn.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		assert.Equal("ou=cas,dc=example,dc=com", req.BaseDN)
+		return &ldap.SearchResult{Entries: []*ldap.Entry{
+			ldap.NewEntry("cn=ca,ou=cas,dc=example,dc=com", map[string][]string{
+				"sshPublicKey": {cas[1]},
+			}),
+		}}, nil
+	}
+
+	cfg.CertAuthority = CertAuthority{
+		Enabled:        true,
+		UserAttr:       "sshCertAuthority",
+		Base:           "ou=cas,dc=example,dc=com",
+		Filter:         "(objectClass=sshCertificateAuthority)",
+		KeyAttr:        "sshPublicKey",
+		PrincipalsAttr: "sshPrincipal",
+	}
+	assert.NoError(cfg.CertAuthority.Check())
+	assert.Equal([]string{"uid", "sshCertAuthority", "sshPrincipal"}, cfg.CertAuthority.userAttrs())
+
+	user := ldap.NewEntry("uid=user,ou=users", map[string][]string{
+		"uid":              {"user"},
+		"sshPrincipal":     {"user-admin", "bad principal", "user"},
+		"sshCertAuthority": {cas[0]},
+	})
+	keys := injectOptions(collectKeys(context.Background(), dir, user), nil)
+	assert.Equal([]userKey{
+		{
+			line:    strCat(`cert-authority,principals="user,user-admin" `, cas[0]),
+			dn:      "uid=user,ou=users",
+			options: []string{"cert-authority", `principals="user,user-admin"`},
+		},
+		{
+			line:    strCat(`cert-authority,principals="user,user-admin" `, cas[1]),
+			dn:      "cn=ca,ou=cas,dc=example,dc=com",
+			options: []string{"cert-authority", `principals="user,user-admin"`},
+		},
+	}, keys)
+
+	// Generated lines have no from option unless key options supply it
+	cfg.LdapServers = []string{"ldap.example.com:389"}
+	cfg.LdapBind, cfg.LdapPass = "cn=keyreader", "secret"
+	cfg.LdapUsers, cfg.LdapGroups, cfg.LdapNetGrs = "ou=users", "ou=groups", "ou=netgroups"
+	cfg.OnlyWithFrom = true
+	assert.EqualError(cfg.Check(), "Certificate authority lines would be rejected without from option, set key_options or stop requiring from")
+	cfg.KeyOptions.Groups = map[string]string{"admins": `from="10.0.0.0/8"`}
+	assert.NoError(cfg.Check())
+	cfg.KeyOptions.Groups = nil
+	cfg.KeyOptions.Attribute = "sshKeyOptions"
+	assert.NoError(cfg.Check())
+	cfg.KeyOptions.Attribute = ""
+	cfg.OnlyWithFrom = false
+	assert.NoError(cfg.Check())
+
+	cfg.CertAuthority.Base = ""
+	cfg.CertAuthority.UserAttr = ""
+	assert.Error(cfg.CertAuthority.Check())
+}
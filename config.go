@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"time"
 
 	u "github.com/iavael/goutil"
 )
@@ -9,8 +10,28 @@ import (
 type Config interface {
 	u.IConfig
 	GetHostnames() []string
+	GetDirectory() string
+	GetDirectories() []NamedDirectory
+	GetDirectoryPolicy() string
+	GetDirectoryConfig(NamedDirectory) Config
+	GetDirectoryFile() string
+	GetHTTPDirectory() *HTTPDirectory
 	GetLdapServers() []string
-	FilterByFrom() bool
+	GetLdapSchema() string
+	GetFreeIPA() *FreeIPA
+	GetActiveDirectory() *ActiveDirectory
+	GetLdapDomain() string
+	GetKeyPolicy() *KeyPolicy
+	GetOptionPolicy() *OptionPolicy
+	GetKeyOptions() *KeyOptions
+	GetKeyEntries() *KeyEntries
+	GetCertAuthority() *CertAuthority
+	GetRoleAccounts() *RoleAccounts
+	GetLocalOverrides() *LocalOverrides
+	GetDedupKeys() bool
+	GetAnnotateKeys() bool
+	GetRevokedKeysKRL() string
+	GetRevokedFingerprints() string
 	GetLdapStartTLS() bool
 	GetLdapIgnoreCert() bool
 	GetLdapBind() string
@@ -18,6 +39,30 @@ type Config interface {
 	GetLdapUsers() string
 	GetLdapGroups() string
 	GetLdapNetGrs() string
+	GetNetgroupBackends() []string
+	GetNetgroupFile() string
+	GetNetgroupLdap() *NetgroupLdap
+	GetNetgroupMatchUser() bool
+	GetNetgroupWildcardHost() string
+	GetNisDomain() string
+	GetLdapConnTimeout() time.Duration
+	GetLdapSearchTimeout() time.Duration
+	GetLdapDeadline() time.Duration
+	GetLdapPageSize() uint32
+	GetLdapSizeLimit() int
+	GetLdapTimeLimit() time.Duration
+	GetLdapReferrals() bool
+	GetLdapReferralHops() int
+	GetLdapReferralBind() string
+	GetLdapParallel() int
+	GetLdapShuffle() bool
+	GetLdapHealthFile() string
+	GetLdapHealthTTL() time.Duration
+}
+
+// configSection is nested part of config validated on its own
+type configSection interface {
+	Check() error
 }
 
 type ConfigVer struct {
@@ -87,6 +132,21 @@ func selectConfig(ver int) u.IConfig {
 		cfg := &ConfigV3{}
 		cfg.LdapStartTLS = true
 		cfg.OnlyWithFrom = true
+		cfg.NetgroupFile = "/etc/netgroup"
+		cfg.FreeIPA.Service = "sshd"
+		cfg.ActiveDirectory.KeyAttr = "altSecurityIdentities"
+		cfg.HTTPDirectory.Timeout = 10 * time.Second
+		cfg.HTTPDirectory.MaxResponse = 1 << 20
+		cfg.NetgroupLdap = NetgroupLdap{BatchSize: 50, Parallel: 1, MaxDepth: 16, Cache: true}
+		cfg.LdapConnTimeout = 5 * time.Second
+		cfg.LdapSearchTimeout = 10 * time.Second
+		cfg.LdapDeadline = 30 * time.Second
+		cfg.LdapHealthTTL = 10 * time.Minute
+		cfg.LdapReferralHops = 3
+		cfg.LdapReferralBind = refBindTrusted
+		cfg.KeyEntries.KeyAttr = "sshPublicKey"
+		cfg.KeyEntries.WarnBefore = 7 * 24 * time.Hour
+		cfg.CertAuthority.KeyAttr = "sshPublicKey"
 		return cfg
 	}
 	return nil
@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	u "github.com/iavael/goutil"
 	"github.com/stretchr/testify/assert"
@@ -15,6 +16,7 @@ version: 3
 hostnames:
   - cool.host.name
   - cool
+onlywithfrom: true
 ldap_servers:
   - ldap1.example.com:389
   - ldap2.example.com:389
@@ -25,6 +27,9 @@ ldap_pass: verysecretpassword
 ldap_base_users: ou=users,dc=example,dc=com
 ldap_base_groups: ou=groups,dc=example,dc=com
 ldap_base_netgrs: ou=netgroups,dc=example,dc=com
+ldap_connect_timeout: 2s
+ldap_search_timeout: 5s
+ldap_deadline: 20s
 `
 )
 
@@ -46,7 +51,7 @@ func TestConfig(t *testing.T) {
 		cfgv3path = tmpfile.Name()
 	}
 
-	if _, err := u.NewMultiConfig(cfgv3path, &ConfigVer{}, func(ver int) u.IConfig {
+	if cfg, err := u.NewMultiConfig(cfgv3path, &ConfigVer{}, func(ver int) u.IConfig {
 		if ver != 3 {
 			return nil
 		}
@@ -55,5 +60,14 @@ func TestConfig(t *testing.T) {
 		return cfg
 	}); err != nil {
 		assert.Fail("Failed config v3 test", err.Error())
+	} else {
+		cfgv3 := cfg.(Config)
+		assert.Equal(2*time.Second, cfgv3.GetLdapConnTimeout())
+		assert.Equal(5*time.Second, cfgv3.GetLdapSearchTimeout())
+		assert.Equal(20*time.Second, cfgv3.GetLdapDeadline())
+		assert.Equal([]string{"from"}, cfgv3.GetOptionPolicy().Require)
+		assert.NoError(cfgv3.Check())
+		assert.Empty(cfgv3.(*ConfigV3).OptionPolicy.Require)
+		assert.Equal([]string{"from"}, cfgv3.GetOptionPolicy().Require)
 	}
 }
@@ -2,28 +2,283 @@ package main
 
 import (
 	"errors"
+	"time"
+
+	u "github.com/iavael/goutil"
 )
 
 type ConfigV3 struct {
-	ConfigBase     `yaml:",inline"`
-	Hostnames      []string `yaml:"hostnames"`
-	OnlyWithFrom   bool     `yaml:"onlywithfrom"`
-	LdapServers    []string `yaml:"ldap_servers"`
-	LdapIgnoreCert bool     `yaml:"ldap_ignorecert"`
+	ConfigBase      `yaml:",inline"`
+	Hostnames       []string        `yaml:"hostnames"`
+	OnlyWithFrom    bool            `yaml:"onlywithfrom"`
+	LdapServers     []string        `yaml:"ldap_servers"`
+	LdapDomain      string          `yaml:"ldap_domain"`
+	LdapIgnoreCert  bool            `yaml:"ldap_ignorecert"`
+	LdapSchema      string          `yaml:"ldap_schema"`
+	FreeIPA         FreeIPA         `yaml:"freeipa"`
+	ActiveDirectory ActiveDirectory `yaml:"active_directory"`
+
+	Directory     string        `yaml:"directory"`
+	DirectoryFile string        `yaml:"directory_file"`
+	HTTPDirectory HTTPDirectory `yaml:"http_directory"`
+
+	Directories     []NamedDirectory `yaml:"directories"`
+	DirectoryPolicy string           `yaml:"directory_policy"`
+
+	NetgroupBackends  []string     `yaml:"netgroup_backends"`
+	NetgroupFile      string       `yaml:"netgroup_file"`
+	NetgroupLdap      NetgroupLdap `yaml:"netgroup_ldap"`
+	NetgroupMatchUser bool         `yaml:"netgroup_match_user"`
+	NetgroupWildcard  string       `yaml:"netgroup_wildcard_host"`
+	NisDomain         string       `yaml:"nis_domain"`
+
+	LdapConnTimeout   time.Duration `yaml:"ldap_connect_timeout"`
+	LdapSearchTimeout time.Duration `yaml:"ldap_search_timeout"`
+	LdapDeadline      time.Duration `yaml:"ldap_deadline"`
+
+	LdapPageSize  uint32        `yaml:"ldap_page_size"`
+	LdapSizeLimit int           `yaml:"ldap_size_limit"`
+	LdapTimeLimit time.Duration `yaml:"ldap_time_limit"`
+
+	LdapReferrals    bool   `yaml:"ldap_referrals"`
+	LdapReferralHops int    `yaml:"ldap_referral_hops"`
+	LdapReferralBind string `yaml:"ldap_referral_bind"`
+
+	LdapParallel   int           `yaml:"ldap_parallel"`
+	LdapShuffle    bool          `yaml:"ldap_shuffle"`
+	LdapHealthFile string        `yaml:"ldap_health_file"`
+	LdapHealthTTL  time.Duration `yaml:"ldap_health_ttl"`
+
+	KeyPolicy     KeyPolicy     `yaml:"key_policy"`
+	KeyOptions    KeyOptions    `yaml:"key_options"`
+	OptionPolicy  OptionPolicy  `yaml:"option_policy"`
+	KeyEntries    KeyEntries    `yaml:"key_entries"`
+	CertAuthority CertAuthority `yaml:"cert_authority"`
+	RoleAccounts  RoleAccounts  `yaml:"role_accounts"`
+
+	LocalOverrides LocalOverrides `yaml:"local_overrides"`
+
+	DedupKeys    bool `yaml:"dedup_keys"`
+	AnnotateKeys bool `yaml:"annotate_keys"`
+
+	RevokedKeysKRL      string `yaml:"revoked_keys_krl"`
+	RevokedFingerprints string `yaml:"revoked_fingerprints"`
 }
 
 func (c *ConfigV3) Check() error {
+	ldapDir := ldapBacked(c.Directory)
+	switch {
+	case !dirBackendValid(c.Directory):
+		return errors.New("Invalid directory backend, need ldap, file or http")
+	case c.Directory == dirBackendFile && len(c.DirectoryFile) == 0:
+		return errors.New("No directory file defined")
+	case c.Directory == dirBackendHTTP && len(c.HTTPDirectory.URL) == 0:
+		return errors.New("No http directory url defined")
+	case c.LdapConnTimeout < 0:
+		return errors.New("Negative ldap connect timeout")
+	case c.LdapSearchTimeout < 0:
+		return errors.New("Negative ldap search timeout")
+	case c.LdapDeadline < 0:
+		return errors.New("Negative ldap deadline")
+	case c.LdapSizeLimit < 0:
+		return errors.New("Negative ldap size limit")
+	case c.LdapTimeLimit < 0:
+		return errors.New("Negative ldap time limit")
+	case c.LdapReferrals && c.LdapReferralHops < 1:
+		return errors.New("Referral hop limit must be positive")
+	case c.LdapReferrals && !refBindValid(c.LdapReferralBind):
+		return errors.New("Invalid ldap referral bind mode, need trusted, reuse or anonymous")
+	case c.LdapParallel < 0:
+		return errors.New("Negative ldap parallel dials count")
+	case c.LdapHealthTTL < 0:
+		return errors.New("Negative ldap health ttl")
+	}
+	for _, backend := range c.NetgroupBackends {
+		if !netgrBackendValid(backend) {
+			return errors.New(strCat("Invalid netgroup backend ", backend, ", need ldap, nss, file or directory"))
+		}
+	}
+	if !wildcardHostValid(c.NetgroupWildcard) {
+		return errors.New("Invalid netgroup wildcard host mode, need deny, allow-any-host or match-user")
+	}
+	for _, section := range []configSection{
+		&c.HTTPDirectory,
+		&c.NetgroupLdap,
+		&c.KeyPolicy,
+		&c.KeyOptions,
+		c.GetOptionPolicy(),
+		&c.KeyEntries,
+		&c.CertAuthority,
+		&c.RoleAccounts,
+	} {
+		if err := section.Check(); err != nil {
+			return err
+		}
+	}
+	if c.CertAuthority.Enabled && !c.caFromSource() {
+		return errors.New("Certificate authority lines would be rejected without from option, set key_options or stop requiring from")
+	}
 	switch {
-	case len(c.LdapServers) == 0:
-		return errors.New("No ldap servers defined")
+	case !ldapDir && len(c.Directories) != 0:
+		return errors.New("Named directories need ldap directory")
+	case !ldapDir:
+		return c.checkNonLdapDirectory()
+	case len(c.Directories) != 0:
+		return c.checkDirectories()
+	}
+	return c.checkLdap()
+}
+
+// caFromSource checks that generated cert-authority lines can get from option when option policy requires it
+func (c *ConfigV3) caFromSource() bool {
+	if !u.MemberOfSlice("from", c.GetOptionPolicy().Require) || len(c.KeyOptions.Attribute) != 0 {
+		return true
+	}
+	for _, tmpl := range c.KeyOptions.Groups {
+		opts, _ := splitOptions(tmpl)
+		for _, opt := range opts {
+			if optionName(opt) == "from" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkLdap validates LDAP servers, credentials, bases and schema
+func (c *ConfigV3) checkLdap() error {
+	switch {
+	case len(c.LdapServers) == 0 && len(c.LdapDomain) == 0:
+		return errors.New("No ldap servers or ldap domain defined")
+	case c.LdapSchema != "" && c.LdapSchema != schemaGosa && c.LdapSchema != schemaFreeIPA && c.LdapSchema != schemaAD:
+		return errors.New("Invalid ldap schema, need gosa, freeipa or ad")
+	case c.LdapSchema == schemaAD && len(c.ActiveDirectory.KeyAttr) == 0:
+		return errors.New("No active directory key attribute defined")
+	}
+	if c.LdapSchema == schemaFreeIPA {
+		if err := c.FreeIPA.Check(); err != nil {
+			return err
+		}
 	}
 	return c.ConfigBase.Check()
 }
 
+// checkDirectories validates directory policy and LDAP settings of every named directory
+func (c *ConfigV3) checkDirectories() error {
+	if !dirPolicyValid(c.DirectoryPolicy) {
+		return errors.New("Invalid directory policy, need first-match or union")
+	}
+	names := map[string]bool{}
+	for _, dir := range c.Directories {
+		switch {
+		case len(dir.Name) == 0:
+			return errors.New("Named directory without name")
+		case names[dir.Name]:
+			return errors.New(strCat("Duplicate directory ", dir.Name))
+		case (len(dir.LdapBind) == 0) != (len(dir.LdapPass) == 0):
+			return errors.New(strCat("Directory ", dir.Name, ": ldap bind and password must be set together"))
+		}
+		names[dir.Name] = true
+		dc := c.GetDirectoryConfig(dir).(*ConfigV3)
+		if err := dc.checkLdap(); err != nil {
+			return errors.New(strCat("Directory ", dir.Name, ": ", err.Error()))
+		}
+		// Union is deny-overrides by trustModel, FreeIPA has no trustModel so its directory could never deny
+		if c.DirectoryPolicy == dirPolicyUnion && dc.LdapSchema == schemaFreeIPA {
+			return errors.New(strCat("Directory ", dir.Name, ": union policy needs trustModel to deny users, freeipa schema has none"))
+		}
+	}
+	return nil
+}
+
+// checkNonLdapDirectory rejects features which need LDAP searches
+func (c *ConfigV3) checkNonLdapDirectory() error {
+	switch {
+	case u.MemberOfSlice(netgrBackendLdap, c.NetgroupBackends):
+		return errors.New("Netgroup backend ldap needs ldap directory")
+	case c.KeyEntries.Enabled:
+		return errors.New("Key entries need ldap directory")
+	case c.CertAuthority.Enabled && len(c.CertAuthority.Base) != 0:
+		return errors.New("Certificate authority base needs ldap directory")
+	case len(c.RoleAccounts.Attribute) != 0:
+		return errors.New("Role accounts attribute needs ldap directory")
+	}
+	for account, role := range c.RoleAccounts.Accounts {
+		if len(role.Groups) != 0 {
+			return errors.New(strCat("Groups of role account ", account, " need ldap directory"))
+		}
+	}
+	return nil
+}
+
 func (c *ConfigV3) GetLdapServers() []string {
 	return c.LdapServers
 }
 
+func (c *ConfigV3) GetLdapSchema() string {
+	return c.LdapSchema
+}
+
+func (c *ConfigV3) GetFreeIPA() *FreeIPA {
+	return &c.FreeIPA
+}
+
+func (c *ConfigV3) GetActiveDirectory() *ActiveDirectory {
+	return &c.ActiveDirectory
+}
+
+func (c *ConfigV3) GetLdapDomain() string {
+	return c.LdapDomain
+}
+
+func (c *ConfigV3) GetDirectory() string {
+	return c.Directory
+}
+
+func (c *ConfigV3) GetDirectories() []NamedDirectory {
+	return c.Directories
+}
+
+func (c *ConfigV3) GetDirectoryPolicy() string {
+	return c.DirectoryPolicy
+}
+
+// GetDirectoryConfig returns copy of config with LDAP settings of dir. Bind DN and password
+// are inherited together and never sent to servers of directory which has its own ones.
+func (c *ConfigV3) GetDirectoryConfig(dir NamedDirectory) Config {
+	dc := *c
+	dc.Directories = nil
+	switch {
+	case len(dir.LdapServers) != 0 || len(dir.LdapDomain) != 0:
+		dc.LdapServers, dc.LdapDomain = dir.LdapServers, dir.LdapDomain
+		dc.LdapBind, dc.LdapPass = dir.LdapBind, dir.LdapPass
+	case len(dir.LdapBind) != 0 || len(dir.LdapPass) != 0:
+		dc.LdapBind, dc.LdapPass = dir.LdapBind, dir.LdapPass
+	}
+	for _, field := range []struct {
+		dst *string
+		src string
+	}{
+		{&dc.LdapUsers, dir.LdapUsers},
+		{&dc.LdapGroups, dir.LdapGroups},
+		{&dc.LdapNetGrs, dir.LdapNetGrs},
+		{&dc.LdapSchema, dir.LdapSchema},
+	} {
+		if len(field.src) != 0 {
+			*field.dst = field.src
+		}
+	}
+	return &dc
+}
+
+func (c *ConfigV3) GetDirectoryFile() string {
+	return c.DirectoryFile
+}
+
+func (c *ConfigV3) GetHTTPDirectory() *HTTPDirectory {
+	return &c.HTTPDirectory
+}
+
 func (c *ConfigV3) GetHostnames() []string {
 	return c.Hostnames
 }
@@ -32,6 +287,128 @@ func (c *ConfigV3) GetLdapIgnoreCert() bool {
 	return c.LdapIgnoreCert
 }
 
-func (c *ConfigV3) FilterByFrom() bool {
-	return c.OnlyWithFrom
+func (c *ConfigV3) GetNetgroupBackends() []string {
+	return c.NetgroupBackends
+}
+
+func (c *ConfigV3) GetNetgroupFile() string {
+	return c.NetgroupFile
+}
+
+func (c *ConfigV3) GetNetgroupLdap() *NetgroupLdap {
+	return &c.NetgroupLdap
+}
+
+func (c *ConfigV3) GetNetgroupMatchUser() bool {
+	return c.NetgroupMatchUser
+}
+
+func (c *ConfigV3) GetNetgroupWildcardHost() string {
+	return c.NetgroupWildcard
+}
+
+func (c *ConfigV3) GetNisDomain() string {
+	return c.NisDomain
+}
+
+func (c *ConfigV3) GetKeyPolicy() *KeyPolicy {
+	return &c.KeyPolicy
+}
+
+// GetOptionPolicy returns option policy with from option required if onlywithfrom is set
+func (c *ConfigV3) GetOptionPolicy() *OptionPolicy {
+	policy := c.OptionPolicy
+	// onlywithfrom is a shortcut for requiring from option
+	if c.OnlyWithFrom && !u.MemberOfSlice("from", policy.Require) {
+		policy.Require = append(append([]string(nil), policy.Require...), "from")
+	}
+	return &policy
+}
+
+func (c *ConfigV3) GetKeyOptions() *KeyOptions {
+	return &c.KeyOptions
+}
+
+func (c *ConfigV3) GetKeyEntries() *KeyEntries {
+	return &c.KeyEntries
+}
+
+func (c *ConfigV3) GetCertAuthority() *CertAuthority {
+	return &c.CertAuthority
+}
+
+func (c *ConfigV3) GetRoleAccounts() *RoleAccounts {
+	return &c.RoleAccounts
+}
+
+func (c *ConfigV3) GetLocalOverrides() *LocalOverrides {
+	return &c.LocalOverrides
+}
+
+func (c *ConfigV3) GetDedupKeys() bool {
+	return c.DedupKeys
+}
+
+func (c *ConfigV3) GetAnnotateKeys() bool {
+	return c.AnnotateKeys
+}
+
+func (c *ConfigV3) GetRevokedKeysKRL() string {
+	return c.RevokedKeysKRL
+}
+
+func (c *ConfigV3) GetRevokedFingerprints() string {
+	return c.RevokedFingerprints
+}
+
+func (c *ConfigV3) GetLdapConnTimeout() time.Duration {
+	return c.LdapConnTimeout
+}
+
+func (c *ConfigV3) GetLdapSearchTimeout() time.Duration {
+	return c.LdapSearchTimeout
+}
+
+func (c *ConfigV3) GetLdapDeadline() time.Duration {
+	return c.LdapDeadline
+}
+
+func (c *ConfigV3) GetLdapPageSize() uint32 {
+	return c.LdapPageSize
+}
+
+func (c *ConfigV3) GetLdapSizeLimit() int {
+	return c.LdapSizeLimit
+}
+
+func (c *ConfigV3) GetLdapTimeLimit() time.Duration {
+	return c.LdapTimeLimit
+}
+
+func (c *ConfigV3) GetLdapReferrals() bool {
+	return c.LdapReferrals
+}
+
+func (c *ConfigV3) GetLdapReferralHops() int {
+	return c.LdapReferralHops
+}
+
+func (c *ConfigV3) GetLdapReferralBind() string {
+	return c.LdapReferralBind
+}
+
+func (c *ConfigV3) GetLdapParallel() int {
+	return c.LdapParallel
+}
+
+func (c *ConfigV3) GetLdapShuffle() bool {
+	return c.LdapShuffle
+}
+
+func (c *ConfigV3) GetLdapHealthFile() string {
+	return c.LdapHealthFile
+}
+
+func (c *ConfigV3) GetLdapHealthTTL() time.Duration {
+	return c.LdapHealthTTL
 }
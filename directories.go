@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	dirPolicyFirst = "first-match"
+	dirPolicyUnion = "union"
+)
+
+// NamedDirectory is one of several LDAP trees, empty settings are taken from top level of config
+type NamedDirectory struct {
+	Name        string   `yaml:"name"`
+	LdapServers []string `yaml:"ldap_servers"`
+	LdapDomain  string   `yaml:"ldap_domain"`
+	LdapBind    string   `yaml:"ldap_bind"`
+	LdapPass    string   `yaml:"ldap_pass"`
+	LdapUsers   string   `yaml:"ldap_base_users"`
+	LdapGroups  string   `yaml:"ldap_base_groups"`
+	LdapNetGrs  string   `yaml:"ldap_base_netgrs"`
+	LdapSchema  string   `yaml:"ldap_schema"`
+}
+
+func dirPolicyValid(name string) bool {
+	switch name {
+	case "", dirPolicyFirst, dirPolicyUnion:
+		return true
+	}
+	return false
+}
+
+// directoriesKeys looks user up in named directories in config order. With first-match policy
+// lookup stops at first directory which authorizes or denies user, with union policy keys of every
+// directory are merged unless any directory denies user. Nonzero code is returned if no directory
+// could be reached or, with union policy, if any of them couldn't.
+func directoriesKeys(ctx context.Context, user string, host *Host, connect func(context.Context, Config) (ldap.Client, int)) ([]userKey, int) {
+	var (
+		union     = config.GetDirectoryPolicy() == dirPolicyUnion
+		keys      []userKey
+		code      int
+		connected bool
+	)
+
+	for _, dir := range config.GetDirectories() {
+		dirKeys, denied, dirCode := directoryKeys(ctx, user, host, config.GetDirectoryConfig(dir), connect)
+		if dirCode != 0 {
+			logger.Error("Failed to look up user %s in directory %s", user, dir.Name)
+			if union {
+				return nil, dirCode
+			}
+			code = dirCode
+			continue
+		}
+		connected = true
+		if denied {
+			logger.Info("User %s is denied by directory %s", user, dir.Name)
+			return nil, 0
+		} else if len(dirKeys) == 0 {
+			debugLog("Directory %s doesn't authorize user %s", dir.Name, user)
+			continue
+		}
+		logger.Info("User %s is authorized by directory %s", user, dir.Name)
+		for i := range dirKeys {
+			dirKeys[i].directory = dir.Name
+		}
+		keys = append(keys, dirKeys...)
+		if !union {
+			break
+		}
+	}
+	if !connected {
+		return nil, code
+	}
+	return keys, 0
+}
+
+// directoryKeys connects to single named directory with its config cfg and returns keys of user,
+// it also reports if directory explicitly denies user
+func directoryKeys(ctx context.Context, user string, host *Host, cfg Config, connect func(context.Context, Config) (ldap.Client, int)) (
+	keys []userKey, denied bool, code int) {
+
+	conn, code := connect(ctx, cfg)
+	if code != 0 {
+		return nil, false, code
+	}
+	defer conn.Close()
+
+	dir, err := newDirectory(cfg, conn)
+	if err != nil {
+		logger.Error("Failed to load directory: %s", err)
+		return nil, false, 29
+	}
+	if directoryDenies(ctx, dir, user) {
+		return nil, true, 0
+	}
+	return lookupKeys(ctx, dir, user, host), false, 0
+}
+
+// directoryDenies checks if user or any of their groups has deny trustModel in dir. Gosa and ad schemas
+// keep trustModel, freeipa has none, so config check rejects it with union policy
+func directoryDenies(ctx context.Context, dir Directory, user string) bool {
+	users, err := dir.findUsers(ctx, user, nil, []string{"trustModel"})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(19)
+	}
+	groups, err := dir.findGroups(ctx, user, nil, []string{"trustModel"})
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(18)
+	}
+	for _, entry := range append(users, groups...) {
+		for _, tm := range entry.GetAttributeValues("trustModel") {
+			if strings.EqualFold(tm, "deny") {
+				debugLog("TrustModel of %s is 'Deny'", entry.DN)
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+const dirTestKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ"
+
+func TestDirectoriesConfig(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+	)
+
+	cfg.LdapServers = []string{"ldap.old.example.com:389"}
+	cfg.LdapBind = "cn=keyreader,dc=example,dc=com"
+	cfg.LdapPass = "secret"
+	cfg.Directories = []NamedDirectory{
+		{
+			Name:       "legacy",
+			LdapUsers:  "ou=users,dc=old",
+			LdapGroups: "ou=groups,dc=old",
+			LdapNetGrs: "ou=netgroups,dc=old",
+		},
+		{
+			Name:       "acme",
+			LdapDomain: "acme.example.com",
+			LdapBind:   "cn=keyreader,dc=acme",
+			LdapUsers:  "ou=people,dc=acme",
+			LdapGroups: "ou=groups,dc=acme",
+			LdapSchema: schemaAD,
+		},
+	}
+	assert.EqualError(cfg.Check(), "Directory acme: ldap bind and password must be set together")
+	acme := cfg.GetDirectoryConfig(cfg.Directories[1])
+	assert.Empty(acme.GetLdapPass())
+	cfg.Directories[1].LdapPass = "acme-secret"
+	assert.EqualError(cfg.Check(), "Directory acme: No active directory key attribute defined")
+	cfg.ActiveDirectory.KeyAttr = "altSecurityIdentities"
+	assert.EqualError(cfg.Check(), "Directory acme: No ldap base for netgroups defined")
+	cfg.Directories[1].LdapNetGrs = "ou=netgroups,dc=acme"
+	assert.NoError(cfg.Check())
+
+	acme = cfg.GetDirectoryConfig(cfg.Directories[1])
+	assert.Equal("cn=keyreader,dc=acme", acme.GetLdapBind())
+	assert.Equal("acme-secret", acme.GetLdapPass())
+	assert.Equal("acme.example.com", acme.GetLdapDomain())
+	assert.Empty(acme.GetLdapServers())
+	assert.Equal(schemaAD, acme.GetLdapSchema())
+	assert.Empty(acme.GetDirectories())
+	assert.Equal("cn=keyreader,dc=example,dc=com", cfg.GetLdapBind())
+	assert.Len(cfg.GetDirectories(), 2)
+
+	// Credentials of top level are never sent to servers of another directory
+	cfg.Directories[1].LdapBind, cfg.Directories[1].LdapPass = "", ""
+	assert.EqualError(cfg.Check(), "Directory acme: No ldap bind defined")
+	acme = cfg.GetDirectoryConfig(cfg.Directories[1])
+	assert.Empty(acme.GetLdapBind())
+	assert.Empty(acme.GetLdapPass())
+	legacy := cfg.GetDirectoryConfig(cfg.Directories[0])
+	assert.Equal([]string{"ldap.old.example.com:389"}, legacy.GetLdapServers())
+	assert.Equal("cn=keyreader,dc=example,dc=com", legacy.GetLdapBind())
+	assert.Equal("secret", legacy.GetLdapPass())
+	cfg.Directories[0].LdapServers = []string{"ldap2.old.example.com:389"}
+	legacy = cfg.GetDirectoryConfig(cfg.Directories[0])
+	assert.Empty(legacy.GetLdapBind())
+	assert.Empty(legacy.GetLdapPass())
+	cfg.Directories[0].LdapServers = nil
+	cfg.Directories[1].LdapBind, cfg.Directories[1].LdapPass = "cn=keyreader,dc=acme", "acme-secret"
+
+	cfg.DirectoryPolicy = "merge"
+	assert.Error(cfg.Check())
+	cfg.DirectoryPolicy = dirPolicyUnion
+	assert.NoError(cfg.Check())
+	cfg.FreeIPA = FreeIPA{HostsBase: "cn=computers,dc=old", HBACBase: "cn=hbac,dc=old", Service: "sshd"}
+	cfg.Directories[0].LdapSchema = schemaFreeIPA
+	assert.EqualError(cfg.Check(), "Directory legacy: union policy needs trustModel to deny users, freeipa schema has none")
+	cfg.DirectoryPolicy = dirPolicyFirst
+	assert.NoError(cfg.Check())
+	cfg.Directories[0].LdapSchema = ""
+	cfg.DirectoryPolicy = dirPolicyUnion
+
+	cfg.Directories[1].Name = "legacy"
+	assert.Error(cfg.Check())
+	cfg.Directories[1].Name = ""
+	assert.Error(cfg.Check())
+	cfg.Directories[1].Name = "acme"
+
+	cfg.Directory = dirBackendFile
+	cfg.DirectoryFile = "testdata/directory.yaml"
+	assert.Error(cfg.Check())
+}
+
+func TestDirectoriesKeys(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		ctx    = context.Background()
+		down   = map[string]bool{}
+		web1   = &Host{names: []string{"web1.example.com"}}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapServers = []string{"old:389"}
+	cfg.LdapBind = "cn=keyreader"
+	cfg.LdapPass = "secret"
+	cfg.Directories = []NamedDirectory{
+		{Name: "legacy", LdapUsers: "ou=users,dc=old", LdapGroups: "ou=groups,dc=old", LdapNetGrs: "ou=ng,dc=old"},
+		{Name: "acme", LdapServers: []string{"acme:389"}, LdapBind: "cn=keyreader,dc=acme", LdapPass: "acme-secret", LdapUsers: "ou=users,dc=acme", LdapGroups: "ou=groups,dc=acme", LdapNetGrs: "ou=ng,dc=acme"},
+	}
+	assert.NoError(cfg.Check())
+
+	// tree holds trustModel of users in each directory
+	tree := map[string]map[string]string{
+		"dc=old":  {"alice": "fullaccess", "carol": "deny"},
+		"dc=acme": {"alice": "fullaccess", "bob": "fullaccess", "carol": "fullaccess"},
+	}
+	connect := func(_ context.Context, dirCfg Config) (ldap.Client, int) {
+		suffix := strings.TrimPrefix(dirCfg.GetLdapUsers(), "ou=users,")
+		if down[suffix] {
+			return nil, 15
+		}
+		return &ClientTest{search: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+			assert.True(strings.HasSuffix(req.BaseDN, suffix), req.BaseDN)
+			if req.BaseDN != dirCfg.GetLdapUsers() {
+				return &ldap.SearchResult{}, nil
+			}
+			for user, tm := range tree[suffix] {
+				if strings.Contains(req.Filter, strCat("(uid=", user, ")")) {
+					return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry(strCat("uid=", user, ",", req.BaseDN), map[string][]string{
+						"trustModel":   {tm},
+						"sshPublicKey": {strCat(dirTestKey, " ", user, "@", suffix)},
+					})}}, nil
+				}
+			}
+			return &ldap.SearchResult{}, nil
+		}}, 0
+	}
+	lines := func(keys []userKey) (res []string) {
+		for _, key := range keys {
+			res = append(res, key.line)
+		}
+		return
+	}
+
+	keys, code := directoriesKeys(ctx, "alice", web1, connect)
+	assert.Zero(code)
+	assert.Equal([]string{dirTestKey + " alice@dc=old"}, lines(keys))
+	assert.Equal(cfg, config)
+	if assert.Len(keys, 1) {
+		assert.Equal("legacy", keys[0].directory)
+		assert.Equal(dirTestKey+" alice@dc=old keyreader: directory=legacy dn=uid=alice,ou=users,dc=old granted by trustModel FullAccess of uid=alice,ou=users,dc=old",
+			annotateKeys(keys)[0].line)
+	}
+
+	keys, code = directoriesKeys(ctx, "bob", web1, connect)
+	assert.Zero(code)
+	assert.Equal([]string{dirTestKey + " bob@dc=acme"}, lines(keys))
+	assert.Equal("acme", keys[0].directory)
+
+	// Denial by first directory isn't overridden by next ones
+	keys, code = directoriesKeys(ctx, "carol", web1, connect)
+	assert.Zero(code)
+	assert.Empty(keys)
+
+	cfg.DirectoryPolicy = dirPolicyUnion
+	keys, code = directoriesKeys(ctx, "alice", web1, connect)
+	assert.Zero(code)
+	assert.Equal([]string{dirTestKey + " alice@dc=old", dirTestKey + " alice@dc=acme"}, lines(keys))
+	keys, code = directoriesKeys(ctx, "carol", web1, connect)
+	assert.Zero(code)
+	assert.Empty(keys)
+
+	down["dc=acme"] = true
+	_, code = directoriesKeys(ctx, "alice", web1, connect)
+	assert.Equal(15, code)
+
+	cfg.DirectoryPolicy = dirPolicyFirst
+	keys, code = directoriesKeys(ctx, "bob", web1, connect)
+	assert.Zero(code)
+	assert.Empty(keys)
+	down["dc=old"] = true
+	_, code = directoriesKeys(ctx, "alice", web1, connect)
+	assert.Equal(15, code)
+	assert.Equal(cfg, config)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	dirBackendLdap = "ldap"
+	dirBackendFile = "file"
+	dirBackendHTTP = "http"
+)
+
+// Directory is source of users, posix groups and netgroups, entries are returned
+// as LDAP entries with the same attributes whatever backend is
+type Directory interface {
+	NetgroupResolver
+	// findUsers returns posix accounts with uid user, when hosts are given only
+	// accounts which may have access to them are required to be returned
+	findUsers(ctx context.Context, user string, hosts []string, attrs []string) ([]*ldap.Entry, error)
+	// findGroups returns posix groups with user as member, hosts are handled like in findUsers
+	findGroups(ctx context.Context, user string, hosts []string, attrs []string) ([]*ldap.Entry, error)
+}
+
+func dirBackendValid(name string) bool {
+	switch name {
+	case "", dirBackendLdap, dirBackendFile, dirBackendHTTP:
+		return true
+	}
+	return false
+}
+
+// ldapBacked checks if directory backend reads entries over LDAP connection
+func ldapBacked(name string) bool {
+	return name != dirBackendFile && name != dirBackendHTTP
+}
+
+// ldapSearcher is implemented by directories read over LDAP, features which need their own
+// LDAP searches (role attribute and groups, key entries, certificate authority base) use its source
+type ldapSearcher interface {
+	source() *ldapSource
+}
+
+// dirSource returns LDAP source of directory, file and http directories have none
+func dirSource(dir Directory) *ldapSource {
+	if searcher, ok := dir.(ldapSearcher); ok {
+		return searcher.source()
+	}
+	return nil
+}
+
+// newDirectory returns directory backend of cfg, LDAP backends search through conn
+func newDirectory(cfg Config, conn ldap.Client) (Directory, error) {
+	switch cfg.GetDirectory() {
+	case dirBackendFile:
+		return loadFileDirectory(cfg.GetDirectoryFile())
+	case dirBackendHTTP:
+		return newHTTPDirectory(cfg.GetHTTPDirectory())
+	}
+	dir := ldapDirectory{ldapNetgroups{newLdapSource(cfg, conn)}}
+	switch cfg.GetLdapSchema() {
+	case schemaFreeIPA:
+		return ipaDirectory{dir}, nil
+	case schemaAD:
+		return adDirectory{dir}, nil
+	}
+	return dir, nil
+}
+
+// ldapDirectory reads posixAccount, posixGroup and nisNetgroup entries over LDAP
+type ldapDirectory struct {
+	ldapNetgroups
+}
+
+func (ldapDirectory) String() string {
+	return dirBackendLdap
+}
+
+func (d ldapDirectory) findUsers(ctx context.Context, user string, hosts []string, attrs []string) ([]*ldap.Entry, error) {
+	usrReq := ldap.NewSearchRequest(
+		d.cfg.GetLdapUsers(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		usrFilter(user, hosts, hosts == nil),
+		attrs,
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, usrReq)
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+func (d ldapDirectory) findGroups(ctx context.Context, user string, hosts []string, attrs []string) ([]*ldap.Entry, error) {
+	grpReq := ldap.NewSearchRequest(
+		d.cfg.GetLdapGroups(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		grpFilter(user, hosts),
+		attrs,
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, grpReq)
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// fileDirectory serves users, groups and netgroups from YAML or JSON file
+type fileDirectory struct {
+	path      string
+	users     []*ldap.Entry
+	groups    []*ldap.Entry
+	netgroups netgroupGraph
+}
+
+// directoryData is layout of directory file
+type directoryData struct {
+	Users     []directoryEntry             `yaml:"users" json:"users"`
+	Groups    []directoryEntry             `yaml:"groups" json:"groups"`
+	Netgroups map[string]directoryNetgroup `yaml:"netgroups" json:"netgroups"`
+}
+
+// directoryEntry is user or group, attributes hold any other LDAP attributes (sshKeyOptions etc.)
+type directoryEntry struct {
+	DN           string              `yaml:"dn" json:"dn"`
+	UID          string              `yaml:"uid" json:"uid"`
+	CN           string              `yaml:"cn" json:"cn"`
+	MemberUID    []string            `yaml:"memberUid" json:"memberUid"`
+	TrustModel   string              `yaml:"trustModel" json:"trustModel"`
+	AccessTo     []string            `yaml:"accessTo" json:"accessTo"`
+	SSHPublicKey []string            `yaml:"sshPublicKey" json:"sshPublicKey"`
+	Attributes   map[string][]string `yaml:"attributes" json:"attributes"`
+}
+
+type directoryNetgroup struct {
+	Triples []string `yaml:"triples" json:"triples"`
+	Members []string `yaml:"members" json:"members"`
+}
+
+func loadFileDirectory(path string) (*fileDirectory, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseFileDirectory(path, buf)
+}
+
+func parseFileDirectory(path string, buf []byte) (*fileDirectory, error) {
+	var data directoryData
+	if strings.HasSuffix(path, ".json") {
+		dec := json.NewDecoder(bytes.NewReader(buf))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&data); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.UnmarshalStrict(buf, &data); err != nil {
+		return nil, err
+	}
+
+	dir := &fileDirectory{path: path, netgroups: netgroupGraph{}}
+	for _, user := range data.Users {
+		if len(user.UID) == 0 {
+			return nil, errors.New("User without uid in directory file")
+		}
+		dir.users = append(dir.users, user.entry(strCat("uid=", user.UID)))
+	}
+	for _, group := range data.Groups {
+		if len(group.CN) == 0 {
+			return nil, errors.New("Group without cn in directory file")
+		}
+		dir.groups = append(dir.groups, group.entry(strCat("cn=", group.CN)))
+	}
+	for name, netgroup := range data.Netgroups {
+		dir.netgroups[name] = &netgroupEntry{triples: netgroup.Triples, children: netgroup.Members}
+	}
+	return dir, nil
+}
+
+func (e directoryEntry) entry(dn string) *ldap.Entry {
+	attrs := map[string][]string{}
+	for name, values := range e.Attributes {
+		attrs[name] = values
+	}
+	for name, values := range map[string][]string{
+		"uid":          {e.UID},
+		"cn":           {e.CN},
+		"trustModel":   {e.TrustModel},
+		"memberUid":    e.MemberUID,
+		"accessTo":     e.AccessTo,
+		"sshPublicKey": e.SSHPublicKey,
+	} {
+		if len(values) != 0 && len(values[0]) != 0 {
+			attrs[name] = values
+		}
+	}
+	if len(e.DN) != 0 {
+		dn = e.DN
+	}
+	return ldap.NewEntry(dn, attrs)
+}
+
+func (d *fileDirectory) String() string {
+	return dirBackendFile
+}
+
+// findUsers returns users with uid, access rules are left to checkAccess
+func (d *fileDirectory) findUsers(_ context.Context, user string, _ []string, _ []string) (res []*ldap.Entry, _ error) {
+	for _, entry := range d.users {
+		if entry.GetAttributeValue("uid") == user {
+			res = append(res, entry)
+		}
+	}
+	return
+}
+
+// findGroups returns groups with user in memberUid, access rules are left to checkAccess
+func (d *fileDirectory) findGroups(_ context.Context, user string, _ []string, _ []string) (res []*ldap.Entry, _ error) {
+	for _, entry := range d.groups {
+		for _, member := range entry.GetAttributeValues("memberUid") {
+			if member == user {
+				res = append(res, entry)
+				break
+			}
+		}
+	}
+	return
+}
+
+func (d *fileDirectory) inNetGroups(_ context.Context, netgroups []string, query netgroupQuery) (bool, error) {
+	return d.netgroups.inNetGroups(netgroups, query)
+}
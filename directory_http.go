@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+// maxHTTPNetgroups limits number of netgroups fetched for single check
+const maxHTTPNetgroups = 1000
+
+// HTTPDirectory describes HTTP service answering with JSON in directory file format:
+// GET <url>/users/<uid>?host=<host> returns list of users, GET <url>/groups?member=<uid>&host=<host>
+// returns list of groups and GET <url>/netgroups/<name> returns netgroup or 404 if there's no such one
+type HTTPDirectory struct {
+	URL         string        `yaml:"url"`
+	CAFile      string        `yaml:"ca_file"`
+	CertFile    string        `yaml:"cert_file"`
+	KeyFile     string        `yaml:"key_file"`
+	Timeout     time.Duration `yaml:"timeout"`
+	MaxResponse int64         `yaml:"max_response_size"`
+}
+
+// Check function validates HTTP directory settings
+func (h *HTTPDirectory) Check() error {
+	if len(h.URL) == 0 {
+		return nil
+	}
+	switch u, err := url.Parse(h.URL); {
+	case err != nil:
+		return errors.New(strCat("Invalid http directory url: ", err.Error()))
+	case u.Scheme != "http" && u.Scheme != "https":
+		return errors.New("Http directory url must be http or https")
+	case (len(h.CertFile) == 0) != (len(h.KeyFile) == 0):
+		return errors.New("Http directory needs both client certificate and key")
+	case h.Timeout < 0:
+		return errors.New("Negative http directory timeout")
+	case h.MaxResponse < 0:
+		return errors.New("Negative http directory response size limit")
+	}
+	return nil
+}
+
+// httpDirectory fetches users, groups and netgroups from HTTP service
+type httpDirectory struct {
+	base    string
+	client  *http.Client
+	maxSize int64
+}
+
+func newHTTPDirectory(settings *HTTPDirectory) (*httpDirectory, error) {
+	tlsConfig := &tls.Config{}
+	if len(settings.CAFile) != 0 {
+		pem, err := ioutil.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New(strCat("No certificates in ", settings.CAFile))
+		}
+	}
+	if len(settings.CertFile) != 0 {
+		cert, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &httpDirectory{
+		// Paths start with /, so base URL with trailing slash would produce //users
+		base: strings.TrimRight(settings.URL, "/"),
+		client: &http.Client{
+			Timeout:   settings.Timeout,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig, Proxy: http.ProxyFromEnvironment},
+			// Redirect may lead to another host or plain http, 3xx is reported as error by get
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		maxSize: settings.MaxResponse,
+	}, nil
+}
+
+func (d *httpDirectory) String() string {
+	return dirBackendHTTP
+}
+
+// get fetches JSON document into res, returns false if server answered 404
+func (d *httpDirectory) get(ctx context.Context, path string, query url.Values, res interface{}) (bool, error) {
+	reqURL := strCat(d.base, path)
+	if len(query) != 0 {
+		reqURL = strCat(reqURL, "?", query.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, fmt.Errorf("Http directory answered %s to %s", resp.Status, path)
+	}
+
+	var body io.Reader = resp.Body
+	if d.maxSize > 0 {
+		if resp.ContentLength > d.maxSize {
+			return false, fmt.Errorf("Http directory response to %s is %d bytes, limit is %d", path, resp.ContentLength, d.maxSize)
+		}
+		body = io.LimitReader(resp.Body, d.maxSize+1)
+	}
+	buf, err := ioutil.ReadAll(body)
+	if err != nil {
+		return false, err
+	}
+	if d.maxSize > 0 && int64(len(buf)) > d.maxSize {
+		return false, fmt.Errorf("Http directory response to %s exceeds %d bytes", path, d.maxSize)
+	}
+	if err := json.Unmarshal(buf, res); err != nil {
+		return false, fmt.Errorf("Invalid http directory response to %s: %s", path, err)
+	}
+	return true, nil
+}
+
+func (d *httpDirectory) findUsers(ctx context.Context, user string, hosts []string, _ []string) (res []*ldap.Entry, err error) {
+	var users []directoryEntry
+	if _, err = d.get(ctx, strCat("/users/", url.PathEscape(user)), url.Values{"host": hosts}, &users); err != nil {
+		return nil, err
+	}
+	for _, entry := range users {
+		if entry.UID != user {
+			logger.Warn("Http directory returned user %s for %s, skipping", entry.UID, user)
+			continue
+		}
+		res = append(res, entry.entry(strCat("uid=", entry.UID)))
+	}
+	return
+}
+
+func (d *httpDirectory) findGroups(ctx context.Context, user string, hosts []string, _ []string) (res []*ldap.Entry, err error) {
+	var groups []directoryEntry
+	if _, err = d.get(ctx, "/groups", url.Values{"member": {user}, "host": hosts}, &groups); err != nil {
+		return nil, err
+	}
+	for _, entry := range groups {
+		if len(entry.CN) == 0 {
+			logger.Warn("Http directory returned group without cn, skipping")
+			continue
+		}
+		res = append(res, entry.entry(strCat("cn=", entry.CN)))
+	}
+	return
+}
+
+// inNetGroups fetches netgroups with their nested netgroups and matches them like file backend
+func (d *httpDirectory) inNetGroups(ctx context.Context, netgroups []string, query netgroupQuery) (bool, error) {
+	var (
+		graph   = netgroupGraph{}
+		visited = map[string]bool{}
+		queue   = append([]string(nil), netgroups...)
+	)
+	for len(queue) > 0 {
+		var name string
+		name, queue = queue[0], queue[1:]
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+
+		var netgroup directoryNetgroup
+		found, err := d.get(ctx, strCat("/netgroups/", url.PathEscape(name)), nil, &netgroup)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			continue
+		}
+		graph[name] = &netgroupEntry{triples: netgroup.Triples, children: netgroup.Members}
+		queue = append(queue, netgroup.Members...)
+		if len(visited) > maxHTTPNetgroups {
+			return false, errors.New(strCat("More than ", strconv.Itoa(maxHTTPNetgroups), " netgroups to fetch from http directory"))
+		}
+	}
+	return graph.inNetGroups(netgroups, query)
+}
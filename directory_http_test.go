@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeClientCert creates client certificate signed by new CA, returns CA pool and paths of cert and key
+func writeClientCert(t *testing.T, dir string) (*x509.CertPool, string, string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "keyreader"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return pool, certFile, keyFile
+}
+
+func TestHTTPDirectory(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		ctx    = context.Background()
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+
+	dir, err := ioutil.TempDir("", "keyreader")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+	clientCAs, certFile, keyFile := writeClientCert(t, dir)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users/alice", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal([]string{"web1.example.com"}, r.URL.Query()["host"])
+		w.Write([]byte(`[{"uid": "alice", "trustModel": "byhost", "accessTo": ["+servers"],
+			"sshPublicKey": ["ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ"]}]`))
+	})
+	mux.HandleFunc("/users/mallory", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[{"uid": "alice", "trustModel": "fullaccess", "sshPublicKey": ["ssh-ed25519 AAAA"]}]`))
+	})
+	mux.HandleFunc("/users/huge", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strCat(`[{"uid": "huge", "sshPublicKey": ["`, strings.Repeat("A", 4096), `"]}]`)))
+	})
+	mux.HandleFunc("/users/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+		w.Write([]byte(`[]`))
+	})
+	mux.HandleFunc("/users/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://attacker.example.com/users/alice", http.StatusFound)
+	})
+	mux.HandleFunc("/groups", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	})
+	mux.HandleFunc("/netgroups/servers", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"triples": ["(web2.example.com,-,)"], "members": ["web", "missing"]}`))
+	})
+	mux.HandleFunc("/netgroups/web", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"triples": ["(web1.example.com,-,)"], "members": ["servers"]}`))
+	})
+
+	srv := httptest.NewUnstartedServer(mux)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	srv.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	srv.StartTLS()
+	defer srv.Close()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+
+	cfg.Directory = dirBackendHTTP
+	cfg.HTTPDirectory = HTTPDirectory{
+		URL:         srv.URL,
+		CAFile:      caFile,
+		CertFile:    certFile,
+		KeyFile:     keyFile,
+		Timeout:     200 * time.Millisecond,
+		MaxResponse: 1024,
+	}
+	assert.NoError(cfg.Check())
+	directory, err := newDirectory(cfg, nil)
+	assert.NoError(err)
+
+	web1 := &Host{names: []string{"web1.example.com"}}
+	keys := userKeys(ctx, directory, "alice", web1)
+	assert.Len(keys, 1)
+	assert.Equal("netgroup in accessTo of uid=alice", keys[0].reason)
+
+	users, err := directory.findUsers(ctx, "mallory", nil, nil)
+	assert.NoError(err)
+	assert.Empty(users)
+
+	_, err = directory.findUsers(ctx, "huge", nil, nil)
+	assert.Error(err)
+	_, err = directory.findUsers(ctx, "slow", nil, nil)
+	assert.Error(err)
+	_, err = directory.findUsers(ctx, "moved", nil, nil)
+	assert.EqualError(err, "Http directory answered 302 Found to /users/moved")
+	users, err = directory.findUsers(ctx, "nobody", nil, nil)
+	assert.NoError(err)
+	assert.Empty(users)
+
+	// Trailing slash of base url doesn't double slash of paths
+	cfg.HTTPDirectory.URL = srv.URL + "/"
+	slashed, err := newDirectory(cfg, nil)
+	assert.NoError(err)
+	users, err = slashed.findUsers(ctx, "alice", web1.names, nil)
+	assert.NoError(err)
+	assert.Len(users, 1)
+	cfg.HTTPDirectory.URL = srv.URL
+
+	cfg.HTTPDirectory.CertFile, cfg.HTTPDirectory.KeyFile = "", ""
+	anonymous, err := newDirectory(cfg, nil)
+	assert.NoError(err)
+	_, err = anonymous.findUsers(ctx, "alice", nil, nil)
+	assert.Error(err)
+
+	cfg.HTTPDirectory.KeyFile = keyFile
+	assert.EqualError(cfg.Check(), "Http directory needs both client certificate and key")
+}
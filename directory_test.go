@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileDirectory(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		ctx    = context.Background()
+		err    error
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.Directory = dirBackendFile
+	cfg.DirectoryFile = filepath.Join("testdata", "directory.yaml")
+	cfg.KeyOptions.Attribute = "sshKeyOptions"
+	assert.NoError(cfg.Check())
+
+	directory, err := newDirectory(cfg, nil)
+	assert.NoError(err)
+	assert.Equal([]NetgroupResolver{directory}, netgroupResolvers(directory))
+
+	web1 := &Host{names: []string{"web1.example.com"}}
+	db2 := &Host{names: []string{"db2.example.com"}}
+
+	keys := userKeys(ctx, directory, "alice", web1)
+	assert.Len(keys, 1)
+	assert.Equal("uid=alice", keys[0].dn)
+	assert.Equal("host web1.example.com in accessTo of uid=alice", keys[0].reason)
+	assert.Empty(userKeys(ctx, directory, "alice", db2))
+
+	keys = userKeys(ctx, directory, "bob", db2)
+	assert.Len(keys, 1)
+	assert.Equal("uid=bob,ou=people,dc=example,dc=com", keys[0].dn)
+	assert.Equal("trustModel FullAccess of cn=admins", keys[0].reason)
+	assert.Equal([]string{"no-pty"}, keys[0].options)
+
+	keys = userKeys(ctx, directory, "carol", db2)
+	assert.Len(keys, 1)
+	assert.Equal("netgroup in accessTo of uid=carol", keys[0].reason)
+	assert.Empty(userKeys(ctx, directory, "carol", web1))
+	assert.Empty(userKeys(ctx, directory, "mallory", web1))
+
+	dir, err := parseFileDirectory("directory.json", []byte(`{
+		"users": [{"uid": "dave", "trustModel": "fullaccess", "sshPublicKey": ["ssh-ed25519 AAAA"]}],
+		"netgroups": {"servers": {"triples": ["(web1.example.com,-,)"]}}
+	}`))
+	assert.NoError(err)
+	users, _ := dir.findUsers(ctx, "dave", nil, nil)
+	assert.Len(users, 1)
+	found, err := dir.inNetGroups(ctx, []string{"servers"}, netgroupQuery{hosts: web1.names})
+	assert.NoError(err)
+	assert.True(found)
+
+	_, err = parseFileDirectory("directory.json", []byte(`{"users": [{"login": "dave"}]}`))
+	assert.Error(err)
+	_, err = parseFileDirectory("directory.yaml", []byte("groups:\n  - memberUid: [dave]\n"))
+	assert.Error(err)
+
+	cfg.KeyEntries = KeyEntries{Enabled: true, Filter: "(objectClass=sshKeyEntry)", KeyAttr: "sshPublicKey"}
+	assert.EqualError(cfg.Check(), "Key entries need ldap directory")
+}
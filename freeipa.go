@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	schemaGosa    = "gosa"
+	schemaFreeIPA = "freeipa"
+
+	ipaKeyAttr = "ipaSshPubKey"
+)
+
+// FreeIPA describes where FreeIPA keeps hosts and HBAC rules
+type FreeIPA struct {
+	HostsBase string `yaml:"hosts_base"`
+	HBACBase  string `yaml:"hbac_base"`
+	Service   string `yaml:"service"`
+}
+
+// Check function validates FreeIPA settings
+func (f *FreeIPA) Check() error {
+	switch {
+	case len(f.HostsBase) == 0:
+		return errors.New("No FreeIPA hosts base defined")
+	case len(f.HBACBase) == 0:
+		return errors.New("No FreeIPA HBAC base defined")
+	case len(f.Service) == 0:
+		return errors.New("No FreeIPA HBAC service defined")
+	}
+	return nil
+}
+
+// accessChecker is implemented by directories with their own authorization model
+// instead of trustModel and accessTo attributes
+type accessChecker interface {
+	authorize(ctx context.Context, user string, host *Host) (bool, string, error)
+}
+
+// ipaDirectory reads FreeIPA users with ipaSshPubKey and authorizes them by HBAC rules
+type ipaDirectory struct {
+	ldapDirectory
+}
+
+func (ipaDirectory) String() string {
+	return schemaFreeIPA
+}
+
+// findUsers returns users with ipaSshPubKey exposed as sshPublicKey
+func (d ipaDirectory) findUsers(ctx context.Context, user string, _ []string, attrs []string) ([]*ldap.Entry, error) {
+	var ipaAttrs []string
+	for _, attr := range attrs {
+		switch attr {
+		case "sshPublicKey":
+			ipaAttrs = append(ipaAttrs, ipaKeyAttr)
+		case "trustModel", "accessTo":
+		default:
+			ipaAttrs = append(ipaAttrs, attr)
+		}
+	}
+	usrReq := ldap.NewSearchRequest(
+		d.cfg.GetLdapUsers(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		ipaUserFilter(user),
+		ipaAttrs,
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, usrReq)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range sr.Entries {
+		for _, attr := range entry.Attributes {
+			if strings.EqualFold(attr.Name, ipaKeyAttr) {
+				attr.Name = "sshPublicKey"
+			}
+		}
+	}
+	return sr.Entries, nil
+}
+
+// findGroups returns user groups of user including nested ones from memberOf
+func (d ipaDirectory) findGroups(ctx context.Context, user string, _ []string, attrs []string) ([]*ldap.Entry, error) {
+	userEntry, err := d.userEntry(ctx, user)
+	if err != nil || userEntry == nil {
+		return nil, err
+	}
+	var filters []string
+	for _, dn := range userEntry.GetAttributeValues("memberOf") {
+		if cn := rdnValue(dn, "cn"); len(cn) != 0 && isUnder(dn, d.cfg.GetLdapGroups()) {
+			filters = append(filters, strCat("(cn=", ldap.EscapeFilter(cn), ")"))
+		}
+	}
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	grpReq := ldap.NewSearchRequest(
+		d.cfg.GetLdapGroups(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		strCat("(&(objectclass=ipausergroup)(|", strCat(filters...), "))"),
+		attrs,
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, grpReq)
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+// ipaUserFilter matches user account unless it's locked with nsAccountLock,
+// locked users have neither keys nor groups
+func ipaUserFilter(user string) string {
+	return strCat("(&(objectclass=posixAccount)(uid=", ldap.EscapeFilter(user), ")(!(nsAccountLock=TRUE)))")
+}
+
+func (d ipaDirectory) userEntry(ctx context.Context, user string) (*ldap.Entry, error) {
+	usrReq := ldap.NewSearchRequest(
+		d.cfg.GetLdapUsers(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		ipaUserFilter(user),
+		[]string{"memberOf"},
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, usrReq)
+	if err != nil {
+		return nil, err
+	}
+	if len(sr.Entries) != 1 {
+		debugLog("Found %d FreeIPA users with uid %s", len(sr.Entries), user)
+		return nil, nil
+	}
+	return sr.Entries[0], nil
+}
+
+// authorize grants access if any enabled allow HBAC rule matches user, host and service
+func (d ipaDirectory) authorize(ctx context.Context, user string, host *Host) (bool, string, error) {
+	var (
+		settings = d.cfg.GetFreeIPA()
+		hostFlts []string
+	)
+
+	userEntry, err := d.userEntry(ctx, user)
+	if err != nil || userEntry == nil {
+		return false, "", err
+	}
+	userDNs := memberDNs(userEntry)
+
+	for _, name := range host.names {
+		hostFlts = append(hostFlts, strCat("(fqdn=", ldap.EscapeFilter(name), ")"))
+	}
+	hostDNs := map[string]bool{}
+	hostEntries, err := d.search(ctx, settings.HostsBase, strCat("(&(objectclass=ipaHost)(|", strCat(hostFlts...), "))"), []string{"memberOf"})
+	if err != nil {
+		return false, "", err
+	}
+	for _, entry := range hostEntries {
+		for dn := range memberDNs(entry) {
+			hostDNs[dn] = true
+		}
+	}
+
+	svcDNs := map[string]bool{}
+	svcEntries, err := d.search(ctx, settings.HBACBase,
+		strCat("(&(objectclass=ipaHBACService)(cn=", ldap.EscapeFilter(settings.Service), "))"), []string{"memberOf"})
+	if err != nil {
+		return false, "", err
+	}
+	for _, entry := range svcEntries {
+		for dn := range memberDNs(entry) {
+			svcDNs[dn] = true
+		}
+	}
+
+	rules, err := d.search(ctx, settings.HBACBase, "(&(objectclass=ipaHBACRule)(ipaEnabledFlag=TRUE))", []string{
+		"cn", "ipaEnabledFlag", "accessRuleType",
+		"userCategory", "memberUser", "hostCategory", "memberHost", "serviceCategory", "memberService",
+	})
+	if err != nil {
+		return false, "", err
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].DN < rules[j].DN })
+
+	for _, rule := range rules {
+		ruleType := rule.GetAttributeValue("accessRuleType")
+		switch {
+		case !strings.EqualFold(rule.GetAttributeValue("ipaEnabledFlag"), "TRUE"):
+			debugLog("HBAC rule %s is disabled", rule.DN)
+		case len(ruleType) != 0 && !strings.EqualFold(ruleType, "allow"):
+			logger.Warn("HBAC rule %s has unsupported type %s, skipping", rule.DN, ruleType)
+		case !hbacMatch(rule, "userCategory", "memberUser", userDNs):
+			debugLog("HBAC rule %s doesn't match user %s", rule.DN, user)
+		case !hbacMatch(rule, "hostCategory", "memberHost", hostDNs):
+			debugLog("HBAC rule %s doesn't match host", rule.DN)
+		case !hbacMatch(rule, "serviceCategory", "memberService", svcDNs):
+			debugLog("HBAC rule %s doesn't match service %s", rule.DN, settings.Service)
+		default:
+			logger.Info("Granting access to user %s by HBAC rule %s", user, rule.GetAttributeValue("cn"))
+			return true, strCat("HBAC rule ", rule.DN), nil
+		}
+	}
+	debugLog("No HBAC rule grants access to user %s", user)
+	return false, "", nil
+}
+
+func (d ipaDirectory) search(ctx context.Context, base, filter string, attrs []string) ([]*ldap.Entry, error) {
+	req := ldap.NewSearchRequest(
+		base,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		attrs,
+		nil,
+	)
+	sr, err := d.ldapSearch(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return sr.Entries, nil
+}
+
+// hbacMatch checks if rule's category is all or any of its members is in dns
+func hbacMatch(rule *ldap.Entry, category, member string, dns map[string]bool) bool {
+	if strings.EqualFold(rule.GetAttributeValue(category), "all") {
+		return true
+	}
+	for _, dn := range rule.GetAttributeValues(member) {
+		if dns[normalizeDN(dn)] {
+			return true
+		}
+	}
+	return false
+}
+
+// memberDNs returns normalized DN of entry and DNs of groups it's member of
+func memberDNs(entry *ldap.Entry) map[string]bool {
+	dns := map[string]bool{normalizeDN(entry.DN): true}
+	for _, dn := range entry.GetAttributeValues("memberOf") {
+		dns[normalizeDN(dn)] = true
+	}
+	return dns
+}
+
+func normalizeDN(dn string) string {
+	return strings.ToLower(strings.Replace(dn, ", ", ",", -1))
+}
+
+// rdnValue returns value of first RDN of dn if its type is attr
+func rdnValue(dn, attr string) string {
+	parsed, err := ldap.ParseDN(dn)
+	if err != nil || len(parsed.RDNs) == 0 {
+		return ""
+	}
+	for _, ava := range parsed.RDNs[0].Attributes {
+		if strings.EqualFold(ava.Type, attr) {
+			return ava.Value
+		}
+	}
+	return ""
+}
+
+func isUnder(dn, base string) bool {
+	return strings.HasSuffix(normalizeDN(dn), strCat(",", normalizeDN(base)))
+}
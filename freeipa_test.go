@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+const ipaTestKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ alice@laptop"
+
+func TestFreeIPA(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		ctx    = context.Background()
+		rules  []*ldap.Entry
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapServers = []string{"ipa.example.com:389"}
+	cfg.LdapBind = "uid=keyreader,cn=sysaccounts,cn=etc,dc=example,dc=com"
+	cfg.LdapPass = "secret"
+	cfg.LdapUsers = "cn=users,cn=accounts,dc=example,dc=com"
+	cfg.LdapGroups = "cn=groups,cn=accounts,dc=example,dc=com"
+	cfg.LdapNetGrs = "cn=ng,cn=alt,dc=example,dc=com"
+	cfg.LdapSchema = schemaFreeIPA
+	cfg.FreeIPA = FreeIPA{
+		HostsBase: "cn=computers,cn=accounts,dc=example,dc=com",
+		HBACBase:  "cn=hbac,dc=example,dc=com",
+	}
+	assert.Error(cfg.Check())
+	cfg.FreeIPA.Service = "sshd"
+	assert.NoError(cfg.Check())
+	cfg.LdapSchema = "nis"
+	assert.Error(cfg.Check())
+	cfg.LdapSchema = schemaFreeIPA
+
+	conn := &ClientTest{}
+	dir, err := newDirectory(cfg, conn)
+	assert.NoError(err)
+	assert.Equal(schemaFreeIPA, dir.String())
+
+	conn.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		var entries []*ldap.Entry
+		switch {
+		case req.BaseDN == cfg.LdapUsers && strings.Contains(req.Filter, "(uid=alice)"):
+			assert.Contains(req.Filter, "(!(nsAccountLock=TRUE))")
+			attrs := map[string][]string{
+				"memberOf": {"cn=admins,cn=groups,cn=accounts,dc=example,dc=com", "cn=ipausers,cn=groups,cn=accounts,dc=example,dc=com"},
+			}
+			for _, attr := range req.Attributes {
+				if attr == ipaKeyAttr {
+					attrs[ipaKeyAttr] = []string{ipaTestKey}
+				}
+				assert.NotEqual("sshPublicKey", attr)
+			}
+			entries = append(entries, ldap.NewEntry("uid=alice,cn=users,cn=accounts,dc=example,dc=com", attrs))
+		case req.BaseDN == cfg.LdapUsers && strings.Contains(req.Filter, "(uid=carol)"):
+			// Locked account matches only filters which don't exclude it
+			if !strings.Contains(req.Filter, "(!(nsAccountLock=TRUE))") {
+				entries = append(entries, ldap.NewEntry("uid=carol,cn=users,cn=accounts,dc=example,dc=com", map[string][]string{
+					"memberOf":      {"cn=admins,cn=groups,cn=accounts,dc=example,dc=com"},
+					"nsAccountLock": {"TRUE"},
+					ipaKeyAttr:      {ipaTestKey},
+				}))
+			}
+		case req.BaseDN == cfg.LdapUsers:
+		case req.BaseDN == cfg.LdapGroups:
+			assert.Equal("(&(objectclass=ipausergroup)(|(cn=admins)(cn=ipausers)))", req.Filter)
+			entries = append(entries, ldap.NewEntry("cn=admins,cn=groups,cn=accounts,dc=example,dc=com",
+				map[string][]string{"cn": {"admins"}}))
+		case req.BaseDN == cfg.FreeIPA.HostsBase:
+			if strings.Contains(req.Filter, "(fqdn=web1.example.com)") {
+				entries = append(entries, ldap.NewEntry("fqdn=web1.example.com,cn=computers,cn=accounts,dc=example,dc=com",
+					map[string][]string{"memberOf": {"cn=webservers,cn=hostgroups,cn=accounts,dc=example,dc=com"}}))
+			}
+		case strings.Contains(req.Filter, "ipaHBACService"):
+			assert.Equal("(&(objectclass=ipaHBACService)(cn=sshd))", req.Filter)
+			entries = append(entries, ldap.NewEntry("cn=sshd,cn=hbacservices,cn=hbac,dc=example,dc=com",
+				map[string][]string{"memberOf": {"cn=Sudo,cn=hbacservicegroups,cn=hbac,dc=example,dc=com"}}))
+		case strings.Contains(req.Filter, "ipaHBACRule"):
+			entries = rules
+		default:
+			t.Fatalf("Unexpected search in %s with %s", req.BaseDN, req.Filter)
+		}
+		return &ldap.SearchResult{Entries: entries}, nil
+	}
+
+	web1 := &Host{names: []string{"web1.example.com"}}
+	db2 := &Host{names: []string{"db2.example.com"}}
+	checker := dir.(accessChecker)
+
+	granted, _, err := checker.authorize(ctx, "alice", web1)
+	assert.NoError(err)
+	assert.False(granted)
+
+	rules = []*ldap.Entry{
+		ldap.NewEntry("ipaUniqueID=1,cn=hbac,dc=example,dc=com", map[string][]string{
+			"cn": {"disabled"}, "ipaEnabledFlag": {"FALSE"}, "accessRuleType": {"allow"},
+			"userCategory": {"all"}, "hostCategory": {"all"}, "serviceCategory": {"all"},
+		}),
+		ldap.NewEntry("ipaUniqueID=2,cn=hbac,dc=example,dc=com", map[string][]string{
+			"cn": {"web_admins"}, "ipaEnabledFlag": {"TRUE"}, "accessRuleType": {"allow"},
+			"memberUser":    {"cn=admins, cn=groups, cn=accounts, dc=example, dc=com"},
+			"memberHost":    {"cn=WebServers,cn=hostgroups,cn=accounts,dc=example,dc=com"},
+			"memberService": {"cn=sshd,cn=hbacservices,cn=hbac,dc=example,dc=com"},
+		}),
+		ldap.NewEntry("ipaUniqueID=3,cn=hbac,dc=example,dc=com", map[string][]string{
+			"cn": {"other_service"}, "ipaEnabledFlag": {"TRUE"},
+			"userCategory": {"all"}, "hostCategory": {"all"},
+			"memberService": {"cn=ftp,cn=hbacservices,cn=hbac,dc=example,dc=com"},
+		}),
+	}
+
+	granted, reason, err := checker.authorize(ctx, "alice", web1)
+	assert.NoError(err)
+	assert.True(granted)
+	assert.Equal("HBAC rule ipaUniqueID=2,cn=hbac,dc=example,dc=com", reason)
+
+	granted, _, err = checker.authorize(ctx, "alice", db2)
+	assert.NoError(err)
+	assert.False(granted)
+
+	granted, _, err = checker.authorize(ctx, "bob", web1)
+	assert.NoError(err)
+	assert.False(granted)
+
+	keys := userKeys(ctx, dir, "alice", web1)
+	if assert.Len(keys, 1) {
+		assert.Equal(ipaTestKey, keys[0].line)
+		assert.Equal(reason, keys[0].reason)
+	}
+	assert.Empty(userKeys(ctx, dir, "alice", db2))
+
+	groups, err := dir.findGroups(ctx, "alice", nil, []string{"cn"})
+	assert.NoError(err)
+	assert.Equal([]string{"admins"}, groupNames(groups))
+
+	rules = append(rules, ldap.NewEntry("ipaUniqueID=0,cn=hbac,dc=example,dc=com", map[string][]string{
+		"cn": {"all"}, "ipaEnabledFlag": {"TRUE"}, "accessRuleType": {"allow"},
+		"userCategory": {"all"}, "hostCategory": {"all"}, "serviceCategory": {"all"},
+	}))
+	granted, reason, err = checker.authorize(ctx, "alice", db2)
+	assert.NoError(err)
+	assert.True(granted)
+	assert.Equal("HBAC rule ipaUniqueID=0,cn=hbac,dc=example,dc=com", reason)
+
+	// Locked user is not found even when rules grant access to everyone
+	assert.Empty(userKeys(ctx, dir, "carol", web1))
+	groups, err = dir.findGroups(ctx, "carol", nil, []string{"cn"})
+	assert.NoError(err)
+	assert.Empty(groups)
+}
+
+func TestFreeIPADN(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("admins", rdnValue("cn=admins,cn=groups,dc=example,dc=com", "CN"))
+	assert.Equal("", rdnValue("uid=admins,cn=groups,dc=example,dc=com", "cn"))
+	assert.Equal("", rdnValue("not a dn", "cn"))
+	assert.True(isUnder("cn=admins, cn=Groups,dc=example,dc=com", "cn=groups,dc=example,dc=com"))
+	assert.False(isUnder("cn=groups,dc=example,dc=com", "cn=groups,dc=example,dc=com"))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// serverHealth keeps timestamps of recent LDAP server failures
+type serverHealth struct {
+	path     string
+	ttl      time.Duration
+	failures map[string]int64
+	dirty    bool
+}
+
+func loadHealth(path string, ttl time.Duration) *serverHealth {
+	if len(path) == 0 {
+		return nil
+	}
+	hl := &serverHealth{
+		path:     path,
+		ttl:      ttl,
+		failures: map[string]int64{},
+	}
+	if buf, err := ioutil.ReadFile(path); err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("Failed to read health file: %s", err)
+		}
+	} else if err := yaml.Unmarshal(buf, &hl.failures); err != nil {
+		logger.Warn("Invalid health file format: %s", err)
+	}
+	return hl
+}
+
+// failedAt returns time of server failure if it's still relevant, 0 otherwise
+func (hl *serverHealth) failedAt(server string) int64 {
+	if hl == nil {
+		return 0
+	}
+	ts, ok := hl.failures[server]
+	if !ok || (hl.ttl > 0 && time.Since(time.Unix(ts, 0)) > hl.ttl) {
+		return 0
+	}
+	return ts
+}
+
+func (hl *serverHealth) failed(server string) {
+	if hl == nil {
+		return
+	}
+	hl.failures[server] = time.Now().Unix()
+	hl.dirty = true
+}
+
+func (hl *serverHealth) succeeded(server string) {
+	if hl == nil {
+		return
+	}
+	if _, ok := hl.failures[server]; ok {
+		delete(hl.failures, server)
+		hl.dirty = true
+	}
+}
+
+func (hl *serverHealth) save() {
+	if hl == nil || !hl.dirty {
+		return
+	}
+	buf, err := yaml.Marshal(hl.failures)
+	if err != nil {
+		logger.Warn("Failed to encode health file: %s", err)
+		return
+	}
+	// Write to temporary file and rename, so concurrent keyreaders never see partial file
+	tmpfile, err := ioutil.TempFile(filepath.Dir(hl.path), ".keyreader-health-")
+	if err != nil {
+		logger.Warn("Failed to write health file: %s", err)
+		return
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write(buf); err != nil {
+		tmpfile.Close()
+		logger.Warn("Failed to write health file: %s", err)
+	} else if err := tmpfile.Close(); err != nil {
+		logger.Warn("Failed to write health file: %s", err)
+	} else if err := os.Rename(tmpfile.Name(), hl.path); err != nil {
+		logger.Warn("Failed to write health file: %s", err)
+	} else {
+		hl.dirty = false
+	}
+}
+
+// orderServers returns servers in the order they should be tried:
+// healthy servers first (shuffled if requested), then recently failed ones
+// starting from the oldest failure
+func orderServers(servers []string, shuffle bool, hl *serverHealth) []string {
+	ordered := append([]string(nil), servers...)
+	if shuffle {
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		rnd.Shuffle(len(ordered), func(i, j int) {
+			ordered[i], ordered[j] = ordered[j], ordered[i]
+		})
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return hl.failedAt(ordered[i]) < hl.failedAt(ordered[j])
+	})
+	return ordered
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		servers = []string{"ldap1:389", "ldap2:389", "ldap3:389"}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+
+	assert.Equal(servers, orderServers(servers, false, nil))
+	assert.ElementsMatch(servers, orderServers(servers, true, nil))
+
+	tmpdir, err := ioutil.TempDir("", "keyreader-test-health-")
+	if err != nil {
+		assert.FailNow("Failed to create tempdir: %s", err)
+	}
+	defer os.RemoveAll(tmpdir)
+	path := filepath.Join(tmpdir, "health")
+
+	hl := loadHealth(path, time.Minute)
+	hl.failed("ldap1:389")
+	hl.save()
+
+	hl = loadHealth(path, time.Minute)
+	assert.NotZero(hl.failedAt("ldap1:389"))
+	assert.Equal([]string{"ldap2:389", "ldap3:389", "ldap1:389"}, orderServers(servers, false, hl))
+
+	hl.failures["ldap2:389"] = time.Now().Add(-time.Hour).Unix()
+	assert.Zero(hl.failedAt("ldap2:389"))
+
+	hl.succeeded("ldap1:389")
+	hl.save()
+	hl = loadHealth(path, time.Minute)
+	assert.Equal(servers, orderServers(servers, false, hl))
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+// KeyEntries describes structured key entries stored under user entry
+type KeyEntries struct {
+	Enabled     bool          `yaml:"enabled"`
+	Filter      string        `yaml:"filter"`
+	KeyAttr     string        `yaml:"key_attr"`
+	ExpiryAttr  string        `yaml:"expiry_attr"`
+	CreatedAttr string        `yaml:"created_attr"`
+	WarnBefore  time.Duration `yaml:"warn_before"`
+	IgnorePlain bool          `yaml:"ignore_plain"`
+}
+
+// Check function validates key entries settings
+func (k *KeyEntries) Check() error {
+	if !k.Enabled {
+		return nil
+	}
+	switch {
+	case len(k.Filter) == 0:
+		return errors.New("No filter for key entries defined")
+	case len(k.KeyAttr) == 0:
+		return errors.New("No key attribute for key entries defined")
+	case k.WarnBefore < 0:
+		return errors.New("Negative key expiry warning period")
+	}
+	if _, err := ldap.CompileFilter(k.Filter); err != nil {
+		return errors.New(strCat("Invalid key entries filter: ", err.Error()))
+	}
+	return nil
+}
+
+// collectKeys returns keys of user entry, its key entries and certificate authorities trusted for user,
+// key entries and authorities base are searched in dir
+func collectKeys(ctx context.Context, dir Directory, entry *ldap.Entry) (keys []userKey) {
+	var (
+		entries = config.GetKeyEntries()
+		options = config.GetKeyOptions().entryOptions(entry)
+		src     = dirSource(dir)
+	)
+	if !entries.Enabled || !entries.IgnorePlain {
+		keys = newUserKeys(entry.DN, entry.GetAttributeValues("sshPublicKey"), options)
+	}
+	if entries.Enabled {
+		keys = append(keys, entries.entryKeys(ctx, src, entry.DN, options)...)
+	}
+	if ca := config.GetCertAuthority(); ca.Enabled {
+		keys = append(keys, ca.caKeys(ctx, src, entry, options)...)
+	}
+	return
+}
+
+// entryKeys returns unexpired keys from key entries below user's DN
+func (k *KeyEntries) entryKeys(ctx context.Context, src *ldapSource, userDN string, options []string) (keys []userKey) {
+	attrs := []string{k.KeyAttr}
+	for _, attr := range []string{k.ExpiryAttr, k.CreatedAttr} {
+		if len(attr) != 0 {
+			attrs = append(attrs, attr)
+		}
+	}
+	keyReq := ldap.NewSearchRequest(
+		userDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		k.Filter,
+		attrs,
+		nil,
+	)
+
+	sr, err := src.ldapSearch(ctx, keyReq)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(25)
+	}
+
+	now := time.Now()
+	for _, entry := range sr.Entries {
+		if entry.DN == userDN {
+			continue
+		}
+		if len(k.ExpiryAttr) != 0 {
+			if value := entry.GetAttributeValue(k.ExpiryAttr); len(value) != 0 {
+				expires, err := parseGeneralizedTime(value)
+				if err != nil {
+					logger.Warn("Invalid %s in DN %s, skipping key: %s", k.ExpiryAttr, entry.DN, err)
+					continue
+				}
+				if !expires.After(now) {
+					logger.Info("Key in DN %s expired at %s, skipping", entry.DN, expires.Format(time.RFC3339))
+					continue
+				}
+				if k.WarnBefore > 0 && expires.Sub(now) < k.WarnBefore {
+					logger.Warn("Key in DN %s expires at %s", entry.DN, expires.Format(time.RFC3339))
+				}
+			}
+		}
+		if len(k.CreatedAttr) != 0 {
+			if value := entry.GetAttributeValue(k.CreatedAttr); len(value) != 0 {
+				if created, err := parseGeneralizedTime(value); err == nil {
+					debugLog("Key in DN %s was created at %s", entry.DN, created.Format(time.RFC3339))
+				}
+			}
+		}
+		keys = append(keys, newUserKeys(entry.DN, entry.GetAttributeValues(k.KeyAttr), options)...)
+	}
+	return
+}
+
+// parseGeneralizedTime parses LDAP GeneralizedTime (RFC 4517)
+func parseGeneralizedTime(value string) (time.Time, error) {
+	var err error
+	for _, layout := range []string{"20060102150405Z0700", "200601021504Z0700", "2006010215Z0700"} {
+		var t time.Time
+		if t, err = time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+func TestKeyEntries(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		format = "20060102150405Z"
+		now    = time.Now().UTC()
+		user   = ldap.NewEntry("uid=user,ou=users", map[string][]string{
+			"sshPublicKey": {"ssh-ed25519 AAAA plain"},
+		})
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	conn := &ClientTest{}
+	dir, err := newDirectory(cfg, conn)
+	assert.NoError(err)
+	conn.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		assert.Equal("uid=user,ou=users", req.BaseDN)
+		return &ldap.SearchResult{Entries: []*ldap.Entry{
+			ldap.NewEntry("cn=valid,uid=user,ou=users", map[string][]string{
+				"sshPublicKey": {"ssh-ed25519 AAAA valid"},
+				"sshKeyExpiry": {now.Add(time.Hour).Format(format)},
+			}),
+			ldap.NewEntry("cn=expired,uid=user,ou=users", map[string][]string{
+				"sshPublicKey": {"ssh-ed25519 AAAA expired"},
+				"sshKeyExpiry": {now.Add(-time.Hour).Format(format)},
+			}),
+			ldap.NewEntry("cn=broken,uid=user,ou=users", map[string][]string{
+				"sshPublicKey": {"ssh-ed25519 AAAA broken"},
+				"sshKeyExpiry": {"tomorrow"},
+			}),
+			ldap.NewEntry("cn=forever,uid=user,ou=users", map[string][]string{
+				"sshPublicKey": {"ssh-ed25519 AAAA forever"},
+			}),
+		}}, nil
+	}
+
+	assert.Equal([]userKey{{line: "ssh-ed25519 AAAA plain", dn: user.DN}}, collectKeys(context.Background(), dir, user))
+
+	cfg.KeyEntries = KeyEntries{
+		Enabled:    true,
+		Filter:     "(objectClass=sshKeyEntry)",
+		KeyAttr:    "sshPublicKey",
+		ExpiryAttr: "sshKeyExpiry",
+		WarnBefore: 24 * time.Hour,
+	}
+	assert.NoError(cfg.KeyEntries.Check())
+	assert.Equal([]userKey{
+		{line: "ssh-ed25519 AAAA plain", dn: user.DN},
+		{line: "ssh-ed25519 AAAA valid", dn: "cn=valid,uid=user,ou=users"},
+		{line: "ssh-ed25519 AAAA forever", dn: "cn=forever,uid=user,ou=users"},
+	}, collectKeys(context.Background(), dir, user))
+
+	cfg.KeyEntries.IgnorePlain = true
+	assert.Len(collectKeys(context.Background(), dir, user), 2)
+
+	ts, err := parseGeneralizedTime("20240102030405.5+0300")
+	assert.NoError(err)
+	assert.Equal(time.Date(2024, 1, 2, 0, 4, 5, 500000000, time.UTC), ts.UTC())
+	_, err = parseGeneralizedTime("2024-01-02")
+	assert.Error(err)
+
+	cfg.KeyEntries.Filter = "(objectClass=sshKeyEntry"
+	assert.Error(cfg.KeyEntries.Check())
+}
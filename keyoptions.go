@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	u "github.com/iavael/goutil"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/ldap.v2"
+)
+
+// Options which may be given several times in one key line
+var multiOptions = []string{"environment", "permitopen", "permitlisten"}
+
+// Flags which are disabled by restrict or their no- counterparts
+var restrictFlags = []string{"agent-forwarding", "port-forwarding", "pty", "user-rc", "x11-forwarding"}
+
+// KeyOptions defines authorized_keys options injected into printed keys
+type KeyOptions struct {
+	Attribute string            `yaml:"attribute"`
+	Groups    map[string]string `yaml:"groups"`
+}
+
+// Check function validates key options templates
+func (o *KeyOptions) Check() error {
+	for group, opts := range o.Groups {
+		if _, err := splitOptions(opts); err != nil {
+			return errors.New(strCat("Invalid key options template for group ", group, ": ", err.Error()))
+		}
+	}
+	return nil
+}
+
+func (o *KeyOptions) enabled() bool {
+	return len(o.Attribute) != 0 || len(o.Groups) != 0
+}
+
+// entryOptions returns options from policy attribute of LDAP entry
+func (o *KeyOptions) entryOptions(entry *ldap.Entry) (res []string) {
+	if len(o.Attribute) == 0 {
+		return nil
+	}
+	for _, value := range entry.GetAttributeValues(o.Attribute) {
+		if opts, err := splitOptions(value); err != nil {
+			logger.Warn("Invalid %s value in DN %s: %s", o.Attribute, entry.DN, err)
+		} else {
+			res = append(res, opts...)
+		}
+	}
+	return
+}
+
+// groupOptions returns options of all groups, ordered by group name
+func (o *KeyOptions) groupOptions(groups []*ldap.Entry) (res []string) {
+	sorted := append([]*ldap.Entry(nil), groups...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].GetAttributeValue("cn") < sorted[j].GetAttributeValue("cn")
+	})
+	for _, group := range sorted {
+		res = append(res, o.entryOptions(group)...)
+		for _, cn := range group.GetAttributeValues("cn") {
+			if tmpl, ok := o.Groups[cn]; ok {
+				opts, _ := splitOptions(tmpl)
+				res = append(res, opts...)
+			}
+		}
+	}
+	return
+}
+
+// splitOptions splits authorized_keys options by commas which are not quoted,
+// control characters are rejected as newline would start another authorized_keys line
+func splitOptions(s string) ([]string, error) {
+	var (
+		res     []string
+		start   int
+		inQuote bool
+	)
+	for i := 0; i < len(s); i++ {
+		if s[i] < ' ' || s[i] == 0x7f {
+			return nil, errors.New("control character in options")
+		}
+	}
+	s = strings.TrimSpace(s)
+	if len(s) == 0 {
+		return nil, nil
+	}
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				res = append(res, s[start:i])
+				start = i + 1
+			}
+		case ' ':
+			if !inQuote {
+				return nil, errors.New("unquoted whitespace in options")
+			}
+		}
+	}
+	if inQuote {
+		return nil, errors.New("unterminated quote in options")
+	}
+	res = append(res, s[start:])
+	for _, opt := range res {
+		if len(opt) == 0 {
+			return nil, errors.New("empty option")
+		}
+	}
+	return res, nil
+}
+
+func optionName(opt string) string {
+	if i := strings.IndexByte(opt, '='); i >= 0 {
+		opt = opt[:i]
+	}
+	return strings.ToLower(opt)
+}
+
+// mergeOptions combines policy options with key's own ones,
+// policy wins for single-valued options, multi-valued ones are accumulated within policy,
+// but own values are dropped if policy sets the option, so key can't widen what policy permits.
+// Own flags re-enabling what policy disables are dropped too
+func mergeOptions(dn string, own, policy []string) []string {
+	var (
+		res      []string
+		seen     = map[string]string{}
+		disabled = map[string]bool{}
+	)
+	for _, opt := range policy {
+		name := optionName(opt)
+		if name == "restrict" {
+			for _, flag := range restrictFlags {
+				disabled[flag] = true
+			}
+		} else if flag := strings.TrimPrefix(name, "no-"); flag != name && u.MemberOfSlice(flag, restrictFlags) {
+			disabled[flag] = true
+		}
+		if prev, ok := seen[name]; ok && !u.MemberOfSlice(name, multiOptions) {
+			if prev != opt {
+				logger.Warn("Conflicting policy option %s for DN %s, using %s", opt, dn, prev)
+			}
+			continue
+		}
+		seen[name] = opt
+		res = append(res, opt)
+	}
+	for _, opt := range own {
+		name := optionName(opt)
+		if disabled[name] {
+			debugLog("Key option %s of DN %s is disabled by policy", opt, dn)
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			debugLog("Key option %s of DN %s is overridden by policy", opt, dn)
+			continue
+		}
+		res = append(res, opt)
+	}
+	return res
+}
+
+// injectOptions rewrites key lines with policy options merged in
+func injectOptions(keys []userKey, policy []string) []userKey {
+	var res []userKey
+	for _, key := range keys {
+		opts := append(append([]string(nil), key.options...), policy...)
+		if len(opts) == 0 {
+			res = append(res, key)
+			continue
+		}
+		pubkey, comment, own, rest, err := ssh.ParseAuthorizedKey([]byte(key.line))
+		if err != nil {
+			logger.Warn("Rejected unparsable key of %s, can't apply key options: %s", key.dn, err)
+			continue
+		} else if len(strings.TrimSpace(string(rest))) != 0 {
+			logger.Warn("Rejected key %s of %s: more than 1 key in value", ssh.FingerprintSHA256(pubkey), key.dn)
+			continue
+		}
+		key.line = formatKey(mergeOptions(key.dn, own, opts), pubkey, comment)
+		res = append(res, key)
+	}
+	return res
+}
+
+func formatKey(opts []string, pubkey ssh.PublicKey, comment string) string {
+	var parts []string
+	if len(opts) != 0 {
+		parts = append(parts, strings.Join(opts, ","))
+	}
+	parts = append(parts, strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(pubkey)), "\n"))
+	if len(comment) != 0 {
+		parts = append(parts, comment)
+	}
+	return strings.Join(parts, " ")
+}
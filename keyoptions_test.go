@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/ldap.v2"
+)
+
+func TestKeyOptions(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		opts   = &KeyOptions{
+			Attribute: "sshKeyOptions",
+			Groups: map[string]string{
+				"deploy": `no-pty,environment="ROLE=deploy"`,
+			},
+		}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+
+	res, err := splitOptions(`command="echo a,b",no-pty,from="10.0.0.0/8,192.168.0.1"`)
+	assert.NoError(err)
+	assert.Equal([]string{`command="echo a,b"`, "no-pty", `from="10.0.0.0/8,192.168.0.1"`}, res)
+	_, err = splitOptions(`command="echo`)
+	assert.Error(err)
+	_, err = splitOptions(`no-pty, no-agent-forwarding`)
+	assert.Error(err)
+	for _, bad := range []string{"command=\"echo\nssh-ed25519 AAAA\"", "command=\"echo\rx\"", "no-pty\n", "command=\"a\\\nb\"", "command=\"a\tb\"", "no-pty,\x7f"} {
+		_, err = splitOptions(bad)
+		assert.EqualError(err, "control character in options", bad)
+	}
+	assert.NoError(opts.Check())
+
+	groups := []*ldap.Entry{
+		ldap.NewEntry("cn=deploy", map[string][]string{
+			"cn":            {"deploy"},
+			"sshKeyOptions": {`command="/usr/bin/deploy"`},
+		}),
+		ldap.NewEntry("cn=admins", map[string][]string{
+			"cn":            {"admins"},
+			"sshKeyOptions": {`no-port-forwarding,environment="TEAM=admins"`},
+		}),
+	}
+	assert.Equal([]string{
+		"no-port-forwarding", `environment="TEAM=admins"`,
+		`command="/usr/bin/deploy"`, "no-pty", `environment="ROLE=deploy"`,
+	}, opts.groupOptions(groups))
+
+	assert.Equal([]string{
+		`command="/usr/bin/deploy"`, `environment="A=1"`, `environment="C=3"`, "no-pty", `permitopen="localhost:80"`,
+	}, mergeOptions("uid=user", []string{`command="/bin/sh"`, `environment="B=2"`, "no-pty", `permitopen="localhost:80"`},
+		[]string{`command="/usr/bin/deploy"`, `environment="A=1"`, `command="/bin/false"`, `environment="C=3"`}))
+	assert.Equal([]string{`permitopen="db:5432"`, `permitlisten="8080"`},
+		mergeOptions("uid=user", []string{`permitopen="*:*"`, `permitopen="db:22"`, `permitlisten="8080"`}, []string{`permitopen="db:5432"`}))
+
+	assert.Equal([]string{"no-pty", "no-port-forwarding"},
+		mergeOptions("uid=user", []string{"pty", "no-port-forwarding"}, []string{"no-pty"}))
+	assert.Equal([]string{"restrict", `command="/usr/bin/deploy"`},
+		mergeOptions("uid=user", []string{"port-forwarding", "X11-forwarding", "Pty", `command="/usr/bin/deploy"`}, []string{"restrict"}))
+	assert.Equal([]string{"no-agent-forwarding", "port-forwarding"},
+		mergeOptions("uid=user", []string{"agent-forwarding", "port-forwarding"}, []string{"no-agent-forwarding"}))
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := ssh.NewPublicKey(pub)
+	line := strCat(`from="*" `, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), " user@host")
+	keys := injectOptions(newUserKeys("uid=user", []string{line}, []string{`from="10.0.0.1"`}), []string{"no-pty"})
+	assert.Len(keys, 1)
+	assert.Equal(strCat(`from="10.0.0.1",no-pty `, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))), " user@host"), keys[0].line)
+
+	keys = newUserKeys("uid=user", []string{line}, nil)
+	assert.Equal(keys, injectOptions(keys, nil))
+}
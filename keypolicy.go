@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"strconv"
+	"strings"
+
+	u "github.com/iavael/goutil"
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	ecdsaCurves = []string{"nistp256", "nistp384", "nistp521"}
+	skKeyTypes  = []string{ssh.KeyAlgoSKED25519, ssh.KeyAlgoSKECDSA256}
+)
+
+// KeyPolicy defines which ssh keys keyreader is allowed to print
+type KeyPolicy struct {
+	DenyTypes       []string `yaml:"deny_types"`
+	MinRSABits      int      `yaml:"min_rsa_bits"`
+	DenyCurves      []string `yaml:"deny_ecdsa_curves"`
+	RequireSKGroups []string `yaml:"require_sk_groups"`
+}
+
+// Check function validates key policy
+func (p *KeyPolicy) Check() error {
+	if p.MinRSABits < 0 {
+		return errors.New("Negative minimal RSA key size")
+	}
+	for _, curve := range p.DenyCurves {
+		if !u.MemberOfSlice(curve, ecdsaCurves) {
+			return errors.New(strCat("Unknown ECDSA curve ", curve))
+		}
+	}
+	return nil
+}
+
+func (p *KeyPolicy) enabled() bool {
+	return len(p.DenyTypes) != 0 || p.MinRSABits != 0 || len(p.DenyCurves) != 0 || len(p.RequireSKGroups) != 0
+}
+
+// needSK checks if any of user's groups requires security keys
+func (p *KeyPolicy) needSK(groups []string) bool {
+	for _, group := range groups {
+		if u.MemberOfSlice(group, p.RequireSKGroups) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkKey returns reason why key violates policy or nil if key is acceptable
+func (p *KeyPolicy) checkKey(key ssh.PublicKey, needSK bool) error {
+	keyType := key.Type()
+	if u.MemberOfSlice(keyType, p.DenyTypes) {
+		return errors.New(strCat("key type ", keyType, " is denied"))
+	}
+	if needSK && !u.MemberOfSlice(keyType, skKeyTypes) {
+		return errors.New(strCat("security key is required, got ", keyType))
+	}
+	if keyType == ssh.KeyAlgoRSA && p.MinRSABits > 0 {
+		if cryptoKey, ok := key.(ssh.CryptoPublicKey); ok {
+			if rsaKey, ok := cryptoKey.CryptoPublicKey().(*rsa.PublicKey); ok {
+				if bits := rsaKey.N.BitLen(); bits < p.MinRSABits {
+					return errors.New(strCat("RSA key has ", strconv.Itoa(bits), " bits, need at least ", strconv.Itoa(p.MinRSABits)))
+				}
+			}
+		}
+	}
+	if curve := ecdsaCurve(keyType); len(curve) != 0 && u.MemberOfSlice(curve, p.DenyCurves) {
+		return errors.New(strCat("ECDSA curve ", curve, " is denied"))
+	}
+	return nil
+}
+
+func ecdsaCurve(keyType string) string {
+	switch {
+	case strings.HasPrefix(keyType, "ecdsa-sha2-"):
+		return strings.TrimPrefix(keyType, "ecdsa-sha2-")
+	case strings.HasPrefix(keyType, "sk-ecdsa-sha2-"):
+		return strings.TrimSuffix(strings.TrimPrefix(keyType, "sk-ecdsa-sha2-"), "@openssh.com")
+	}
+	return ""
+}
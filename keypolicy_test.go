@@ -0,0 +1,68 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		policy = &cfg.KeyPolicy
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+
+	rsaPriv, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsaKey, _ := ssh.NewPublicKey(&rsaPriv.PublicKey)
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	edKey, _ := ssh.NewPublicKey(edPub)
+	ecPriv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecKey, _ := ssh.NewPublicKey(&ecPriv.PublicKey)
+	skKey, err := ssh.ParsePublicKey(ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, make([]byte, ed25519.PublicKeySize), "ssh:"}))
+	assert.NoError(err)
+
+	assert.False(policy.enabled())
+	keys := newUserKeys("uid=user", []string{"garbage", string(ssh.MarshalAuthorizedKey(rsaKey))}, nil)
+	assert.Equal(keys, filterKeys(keys, false))
+
+	policy.MinRSABits = 2048
+	policy.DenyCurves = []string{"nistp256"}
+	policy.DenyTypes = []string{ssh.KeyAlgoDSA}
+	policy.RequireSKGroups = []string{"admins"}
+	assert.NoError(policy.Check())
+	assert.True(policy.enabled())
+
+	assert.Error(policy.checkKey(rsaKey, false))
+	assert.Error(policy.checkKey(ecKey, false))
+	assert.NoError(policy.checkKey(edKey, false))
+	assert.Error(policy.checkKey(edKey, true))
+	assert.NoError(policy.checkKey(skKey, true))
+
+	assert.True(policy.needSK([]string{"users", "admins"}))
+	assert.False(policy.needSK([]string{"users"}))
+
+	keys = newUserKeys("uid=user", []string{
+		"garbage",
+		string(ssh.MarshalAuthorizedKey(rsaKey)),
+		string(ssh.MarshalAuthorizedKey(edKey)),
+	}, nil)
+	assert.Equal(keys[2:], filterKeys(keys, false))
+
+	policy.DenyCurves = []string{"nistp255"}
+	assert.Error(policy.Check())
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	u "github.com/iavael/goutil"
+	"golang.org/x/crypto/ssh"
+)
+
+// userKey is ssh public key read from directory
+type userKey struct {
+	line      string
+	dn        string
+	reason    string
+	owner     string
+	directory string
+	options   []string
+}
+
+func newUserKeys(dn string, lines []string, options []string) []userKey {
+	keys := make([]userKey, 0, len(lines))
+	for _, line := range lines {
+		keys = append(keys, userKey{line: line, dn: dn, options: options})
+	}
+	return keys
+}
+
+// filterKeys drops keys which violate key policy
+func filterKeys(keys []userKey, needSK bool) []userKey {
+	policy := config.GetKeyPolicy()
+	if !policy.enabled() {
+		return keys
+	}
+
+	var res []userKey
+	for _, key := range keys {
+		pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.line))
+		if err != nil {
+			logger.Warn("Rejected unparsable key of %s: %s", key.dn, err)
+			continue
+		}
+		if err := policy.checkKey(pubkey, needSK); err != nil {
+			logger.Warn("Rejected key %s of %s: %s", ssh.FingerprintSHA256(pubkey), key.dn, err)
+			continue
+		}
+		res = append(res, key)
+	}
+	return res
+}
+
+// filterRevoked drops keys found in revocation list
+func filterRevoked(keys []userKey, rl *revocationList) []userKey {
+	if rl == nil {
+		return keys
+	}
+
+	var res []userKey
+	for _, key := range keys {
+		pubkey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(key.line))
+		if err != nil {
+			logger.Warn("Rejected unparsable key of %s, can't check revocation: %s", key.dn, err)
+			continue
+		}
+		if rl.revoked(pubkey) {
+			logger.Warn("Rejected revoked key %s of %s", ssh.FingerprintSHA256(pubkey), key.dn)
+			continue
+		}
+		res = append(res, key)
+	}
+	return res
+}
+
+// filterOptions drops keys which options violate option policy
+func filterOptions(keys []userKey) []userKey {
+	policy := config.GetOptionPolicy()
+	if !policy.enabled() {
+		return keys
+	}
+
+	var res []userKey
+	for _, key := range keys {
+		pubkey, _, opts, rest, err := ssh.ParseAuthorizedKey([]byte(key.line))
+		if err != nil {
+			logger.Warn("Rejected unparsable key of %s: %s", key.dn, err)
+			continue
+		}
+		if len(bytes.TrimSpace(rest)) != 0 {
+			logger.Warn("Rejected key %s of %s: more than 1 key in value", ssh.FingerprintSHA256(pubkey), key.dn)
+			continue
+		}
+		if err := policy.checkOptions(opts); err != nil {
+			logger.Warn("Rejected key %s of %s: %s", ssh.FingerprintSHA256(pubkey), key.dn, err)
+			continue
+		}
+		res = append(res, key)
+	}
+	return res
+}
+
+// normalizeKeys sorts keys by fingerprint and drops duplicates, of several variants
+// of the same key most restrictive one wins, lexicographically first one on tie
+func normalizeKeys(keys []userKey) []userKey {
+	type normKey struct {
+		userKey
+		fp    string
+		score int
+	}
+
+	var (
+		norm = make([]normKey, 0, len(keys))
+		res  []userKey
+	)
+	for _, key := range keys {
+		nk := normKey{userKey: key, fp: strings.TrimSpace(key.line)}
+		if pubkey, _, opts, _, err := ssh.ParseAuthorizedKey([]byte(key.line)); err == nil {
+			nk.fp = ssh.FingerprintSHA256(pubkey)
+			nk.score = restrictiveness(opts)
+		}
+		norm = append(norm, nk)
+	}
+	sort.SliceStable(norm, func(i, j int) bool {
+		switch {
+		case norm[i].fp != norm[j].fp:
+			return norm[i].fp < norm[j].fp
+		case norm[i].score != norm[j].score:
+			return norm[i].score > norm[j].score
+		}
+		return norm[i].line < norm[j].line
+	})
+	for i, key := range norm {
+		if i > 0 && norm[i-1].fp == key.fp {
+			debugLog("Dropped duplicate key %s of %s", key.fp, key.dn)
+			continue
+		}
+		res = append(res, key.userKey)
+	}
+	return res
+}
+
+// restrictiveness scores key options, options which limit key count for it and ones which widen it count against it
+func restrictiveness(opts []string) (score int) {
+	for _, opt := range opts {
+		switch name := optionName(opt); {
+		case name == "restrict":
+			score += len(restrictFlags)
+		case u.MemberOfSlice(name, restrictFlags) || name == "environment" || name == "no-touch-required":
+			score--
+		default:
+			score++
+		}
+	}
+	return
+}
+
+// annotateKeys appends owner, source DN and grant reason to key comments
+func annotateKeys(keys []userKey) []userKey {
+	return annotate(keys, true)
+}
+
+// annotateOwners appends owner to comments of role account keys
+func annotateOwners(keys []userKey) []userKey {
+	return annotate(keys, false)
+}
+
+func annotate(keys []userKey, full bool) []userKey {
+	var res []userKey
+	for _, key := range keys {
+		if !full && len(key.owner) == 0 {
+			res = append(res, key)
+			continue
+		}
+		pubkey, comment, opts, _, err := ssh.ParseAuthorizedKey([]byte(key.line))
+		if err != nil {
+			logger.Warn("Rejected unparsable key of %s, can't annotate it: %s", key.dn, err)
+			continue
+		}
+		note := "keyreader:"
+		if len(key.owner) != 0 {
+			note = strCat(note, " owner=", key.owner)
+		}
+		if full {
+			if len(key.directory) != 0 {
+				note = strCat(note, " directory=", key.directory)
+			}
+			note = strCat(note, " dn=", key.dn)
+			if len(key.reason) != 0 {
+				note = strCat(note, " granted by ", key.reason)
+			}
+		}
+		if len(comment) != 0 {
+			note = strCat(comment, " ", note)
+		}
+		key.line = formatKey(opts, pubkey, note)
+		res = append(res, key)
+	}
+	return res
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestNormalizeKeys(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		lines  []string
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+
+	for i := 0; i < 2; i++ {
+		pub, _, _ := ed25519.GenerateKey(rand.Reader)
+		key, _ := ssh.NewPublicKey(pub)
+		lines = append(lines, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))))
+	}
+
+	keys := newUserKeys("uid=user", []string{
+		strCat("no-pty ", lines[0], " laptop"),
+		lines[1],
+		strCat(lines[0], " laptop"),
+		lines[1],
+	}, nil)
+	norm := normalizeKeys(keys)
+	assert.Len(norm, 2)
+	assert.Equal(norm, normalizeKeys([]userKey{keys[3], keys[2], keys[1], keys[0]}))
+	assert.Contains([]string{norm[0].line, norm[1].line}, strCat("no-pty ", lines[0], " laptop"))
+
+	// Variant with more options isn't kept if it's less restrictive
+	keys = newUserKeys("uid=user", []string{
+		strCat(`pty,agent-forwarding,environment="A=1" `, lines[0]),
+		strCat(`restrict `, lines[0]),
+		strCat(`from="10.0.0.1" `, lines[0]),
+	}, nil)
+	norm = normalizeKeys(keys)
+	if assert.Len(norm, 1) {
+		assert.Equal(strCat("restrict ", lines[0]), norm[0].line)
+	}
+	assert.Equal(keys[2:], normalizeKeys([]userKey{keys[0], keys[2]}))
+
+	keys = []userKey{
+		{line: strCat(lines[0], " laptop"), dn: "uid=user", reason: "host example.com in accessTo of uid=user"},
+		{line: lines[1], dn: "uid=user"},
+	}
+	assert.Equal([]userKey{
+		{line: strCat(lines[0], " laptop keyreader: dn=uid=user granted by host example.com in accessTo of uid=user"),
+			dn: "uid=user", reason: "host example.com in accessTo of uid=user"},
+		{line: strCat(lines[1], " keyreader: dn=uid=user"), dn: "uid=user"},
+	}, annotateKeys(keys))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// OpenSSH KRL format is described in PROTOCOL.krl
+const (
+	krlMagic         = "SSHKRL\n\x00"
+	krlFormatVersion = 1
+
+	krlSectionCertificates = 1
+	krlSectionExplicitKey  = 2
+	krlSectionSHA1         = 3
+	krlSectionSignature    = 4
+	krlSectionSHA256       = 5
+	krlSectionExtension    = 255
+)
+
+// revocationList holds revoked keys from KRL and fingerprint list
+type revocationList struct {
+	blobs        map[string]bool
+	sha1         map[string]bool
+	sha256       map[string]bool
+	fingerprints map[string]bool
+}
+
+func newRevocationList() *revocationList {
+	return &revocationList{
+		blobs:        map[string]bool{},
+		sha1:         map[string]bool{},
+		sha256:       map[string]bool{},
+		fingerprints: map[string]bool{},
+	}
+}
+
+// loadRevocations reads configured KRL and fingerprints files, nil means revocation is not configured
+func loadRevocations() (*revocationList, error) {
+	var (
+		krlPath = config.GetRevokedKeysKRL()
+		fpPath  = config.GetRevokedFingerprints()
+	)
+	if len(krlPath) == 0 && len(fpPath) == 0 {
+		return nil, nil
+	}
+
+	rl := newRevocationList()
+	if len(krlPath) != 0 {
+		if buf, err := ioutil.ReadFile(krlPath); err != nil {
+			return nil, err
+		} else if err := rl.parseKRL(buf); err != nil {
+			return nil, errors.New(strCat("Invalid KRL ", krlPath, ": ", err.Error()))
+		}
+	}
+	if len(fpPath) != 0 {
+		if file, err := os.Open(fpPath); err != nil {
+			return nil, err
+		} else {
+			defer file.Close()
+			if err := rl.parseFingerprints(file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rl, nil
+}
+
+func (rl *revocationList) parseKRL(buf []byte) error {
+	if !bytes.HasPrefix(buf, []byte(krlMagic)) {
+		return errors.New("bad magic")
+	}
+	buf = buf[len(krlMagic):]
+
+	// format version, krl version, generated date and flags
+	if len(buf) < 4+8+8+8 {
+		return errors.New("truncated header")
+	}
+	if binary.BigEndian.Uint32(buf) != krlFormatVersion {
+		return errors.New("unsupported format version")
+	}
+	buf = buf[4+8+8+8:]
+
+	var err error
+	// reserved and comment
+	for i := 0; i < 2; i++ {
+		if _, buf, err = krlString(buf); err != nil {
+			return err
+		}
+	}
+
+	for len(buf) > 0 {
+		var (
+			section = buf[0]
+			data    []byte
+		)
+		if data, buf, err = krlString(buf[1:]); err != nil {
+			return err
+		}
+		switch section {
+		case krlSectionExplicitKey:
+			err = krlStrings(data, rl.blobs)
+		case krlSectionSHA1:
+			err = krlStrings(data, rl.sha1)
+		case krlSectionSHA256:
+			err = krlStrings(data, rl.sha256)
+		case krlSectionCertificates:
+			debugLog("Skipping KRL certificates section, sshd checks certificates itself")
+		case krlSectionExtension:
+			err = krlExtension(data)
+		case krlSectionSignature:
+			// Signatures are optional and always go last
+			return nil
+		default:
+			return errors.New("unknown section type")
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// krlExtension checks extension section, unknown extensions are skipped unless they are critical
+func krlExtension(buf []byte) error {
+	name, buf, err := krlString(buf)
+	if err != nil {
+		return err
+	}
+	if len(buf) < 1 {
+		return errors.New("truncated extension")
+	}
+	if buf[0] != 0 {
+		return errors.New(strCat("unsupported critical extension ", string(name)))
+	}
+	debugLog("Skipping unknown KRL extension %s", name)
+	return nil
+}
+
+func krlString(buf []byte) ([]byte, []byte, error) {
+	if len(buf) < 4 {
+		return nil, nil, errors.New("truncated string")
+	}
+	length := binary.BigEndian.Uint32(buf)
+	if uint64(len(buf)-4) < uint64(length) {
+		return nil, nil, errors.New("truncated string")
+	}
+	return buf[4 : 4+length], buf[4+length:], nil
+}
+
+func krlStrings(buf []byte, set map[string]bool) error {
+	for len(buf) > 0 {
+		var (
+			value []byte
+			err   error
+		)
+		if value, buf, err = krlString(buf); err != nil {
+			return err
+		}
+		set[string(value)] = true
+	}
+	return nil
+}
+
+// parseFingerprints reads one fingerprint per line, ssh-keygen -l output is accepted too
+func (rl *revocationList) parseFingerprints(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		found := false
+		for _, field := range strings.Fields(line) {
+			if strings.HasPrefix(field, "SHA256:") || strings.HasPrefix(field, "MD5:") {
+				rl.fingerprints[field] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			logger.Warn("No fingerprint in revocation list line %s", line)
+		}
+	}
+	return scanner.Err()
+}
+
+func (rl *revocationList) revoked(key ssh.PublicKey) bool {
+	blob := key.Marshal()
+	sha1sum := sha1.Sum(blob)
+	sha256sum := sha256.Sum256(blob)
+	return rl.blobs[string(blob)] ||
+		rl.sha1[string(sha1sum[:])] ||
+		rl.sha256[string(sha256sum[:])] ||
+		rl.fingerprints[ssh.FingerprintSHA256(key)] ||
+		rl.fingerprints[strCat("MD5:", ssh.FingerprintLegacyMD5(key))]
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func krlTestString(buf *bytes.Buffer, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+}
+
+func krlTestSection(buf *bytes.Buffer, section byte, values ...[]byte) {
+	var data bytes.Buffer
+	for _, value := range values {
+		krlTestString(&data, value)
+	}
+	buf.WriteByte(section)
+	krlTestString(buf, data.Bytes())
+}
+
+func krlTestExtension(name string, critical bool) []byte {
+	var data bytes.Buffer
+	krlTestString(&data, []byte(name))
+	if critical {
+		data.WriteByte(1)
+	} else {
+		data.WriteByte(0)
+	}
+	krlTestString(&data, []byte("contents"))
+	return data.Bytes()
+}
+
+func TestKRL(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		keys   []ssh.PublicKey
+		krl    bytes.Buffer
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+
+	for i := 0; i < 4; i++ {
+		pub, _, _ := ed25519.GenerateKey(rand.Reader)
+		key, _ := ssh.NewPublicKey(pub)
+		keys = append(keys, key)
+	}
+
+	krl.WriteString(krlMagic)
+	binary.Write(&krl, binary.BigEndian, uint32(krlFormatVersion))
+	binary.Write(&krl, binary.BigEndian, [3]uint64{1, 1600000000, 0})
+	krlTestString(&krl, nil)
+	krlTestString(&krl, []byte("test krl"))
+	krlTestSection(&krl, krlSectionExplicitKey, keys[0].Marshal())
+	sum := sha256.Sum256(keys[1].Marshal())
+	krlTestSection(&krl, krlSectionSHA256, sum[:])
+	krl.WriteByte(krlSectionExtension)
+	krlTestString(&krl, krlTestExtension("unknown@example.com", false))
+
+	rl := newRevocationList()
+	assert.NoError(rl.parseKRL(krl.Bytes()))
+	assert.NoError(rl.parseFingerprints(strings.NewReader(strCat(
+		"# revoked by security team\n",
+		"256 ", ssh.FingerprintSHA256(keys[2]), " user@host (ED25519)\n",
+	))))
+
+	assert.True(rl.revoked(keys[0]))
+	assert.True(rl.revoked(keys[1]))
+	assert.True(rl.revoked(keys[2]))
+	assert.False(rl.revoked(keys[3]))
+
+	userKeys := newUserKeys("uid=user", []string{
+		string(ssh.MarshalAuthorizedKey(keys[0])),
+		string(ssh.MarshalAuthorizedKey(keys[3])),
+	}, nil)
+	assert.Equal(userKeys[1:], filterRevoked(userKeys, rl))
+	assert.Equal(userKeys, filterRevoked(userKeys, nil))
+
+	assert.Error(rl.parseKRL([]byte("SSHKRL\n\x00\x00")))
+	assert.Error(rl.parseKRL(krl.Bytes()[:krl.Len()-1]))
+
+	krl.WriteByte(krlSectionExtension)
+	krlTestString(&krl, krlTestExtension("critical@example.com", true))
+	assert.EqualError(rl.parseKRL(krl.Bytes()), "unsupported critical extension critical@example.com")
+}
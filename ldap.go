@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/ldap.v2"
+)
+
+// ldapSource is connection to LDAP directory together with config of that directory,
+// every LDAP directory searches through its own source, so several of them may be used at once
+type ldapSource struct {
+	cfg  Config
+	conn ldap.Client
+	// netgroups holds netgroups fetched through this source
+	netgroups *netgroupCache
+}
+
+func newLdapSource(cfg Config, conn ldap.Client) *ldapSource {
+	return &ldapSource{cfg: cfg, conn: conn, netgroups: &netgroupCache{entries: map[string]*netgroupEntry{}}}
+}
+
+func (s *ldapSource) source() *ldapSource {
+	return s
+}
+
+// connLdap connects to LDAP servers of cfg and binds with its credentials
+func connLdap(ctx context.Context, cfg Config) (ldap.Client, int) {
+	var code = -1
+
+	hl := loadHealth(cfg.GetLdapHealthFile(), cfg.GetLdapHealthTTL())
+	defer hl.save()
+
+	servers, shuffle := cfg.GetLdapServers(), cfg.GetLdapShuffle()
+	if len(servers) == 0 {
+		if servers = discoverServers(ctx, net.DefaultResolver, cfg.GetLdapDomain()); len(servers) == 0 {
+			logger.Error("No LDAP servers discovered for domain %s", cfg.GetLdapDomain())
+			return nil, 22
+		}
+		// Discovered servers are already ordered by SRV priority and weight
+		shuffle = false
+	}
+	servers = orderServers(servers, shuffle, hl)
+	debugLog("LDAP servers order: %s", strings.Join(servers, ", "))
+	dialer := newLdapDialer(cfg, cfg.GetLdapBind(), cfg.GetLdapPass())
+	if parallel := cfg.GetLdapParallel(); parallel > 1 {
+		return raceLdap(ctx, dialer, servers, parallel, hl)
+	}
+
+	for _, server := range servers {
+		var conn ldap.Client
+		if conn, code = dialer.dial(ctx, server); code == 0 {
+			hl.succeeded(server)
+			return conn, 0
+		}
+		hl.failed(server)
+		exitOnDeadline(ctx)
+	}
+	return nil, code
+}
+
+// raceLdap dials up to parallel servers at once and returns first successfully bound connection
+func raceLdap(ctx context.Context, dialer ldapDialer, servers []string, parallel int, hl *serverHealth) (ldap.Client, int) {
+	type dialResult struct {
+		server string
+		conn   ldap.Client
+		code   int
+	}
+
+	var (
+		code    = -1
+		next    int
+		running int
+		results = make(chan dialResult, len(servers))
+	)
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for {
+		for ; running < parallel && next < len(servers); next, running = next+1, running+1 {
+			go func(server string) {
+				conn, code := dialer.dial(raceCtx, server)
+				results <- dialResult{server, conn, code}
+			}(servers[next])
+		}
+		if running == 0 {
+			return nil, code
+		}
+
+		res := <-results
+		running--
+		if res.code == 0 {
+			debugLog("LDAP server %s won the race", res.server)
+			hl.succeeded(res.server)
+			// Close connections of servers which were late
+			go func(late int) {
+				for ; late > 0; late-- {
+					if r := <-results; r.conn != nil {
+						r.conn.Close()
+					}
+				}
+			}(running)
+			return res.conn, 0
+		}
+		hl.failed(res.server)
+		code = res.code
+		exitOnDeadline(ctx)
+	}
+}
+
+// dialLdapAs connects to server with settings of cfg and binds with given credentials,
+// empty bind DN means anonymous access
+func dialLdapAs(ctx context.Context, cfg Config, server, bind, pass string) (ldap.Client, int) {
+	return newLdapDialer(cfg, bind, pass).dial(ctx, server)
+}
+
+// ldapDialer holds connection settings taken from config before dialing
+type ldapDialer struct {
+	bind, pass    string
+	connTimeout   time.Duration
+	searchTimeout time.Duration
+	ignoreCert    bool
+	startTLS      bool
+}
+
+func newLdapDialer(cfg Config, bind, pass string) ldapDialer {
+	return ldapDialer{
+		bind:          bind,
+		pass:          pass,
+		connTimeout:   cfg.GetLdapConnTimeout(),
+		searchTimeout: cfg.GetLdapSearchTimeout(),
+		ignoreCert:    cfg.GetLdapIgnoreCert(),
+		startTLS:      cfg.GetLdapStartTLS(),
+	}
+}
+
+func (d ldapDialer) dial(ctx context.Context, server string) (ldap.Client, int) {
+	addr, implicitTLS := parseServer(server)
+	dialer := net.Dialer{Timeout: d.connTimeout}
+	sock, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		dialError(ctx, err)
+		return nil, 15
+	}
+
+	// StartTLS handshake and bind must fit in connect timeout too
+	sock.SetDeadline(connDeadline(ctx, d.connTimeout))
+
+	hostname := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		hostname = h
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: d.ignoreCert,
+		ServerName:         hostname,
+	}
+
+	var conn *ldap.Conn
+	if implicitTLS {
+		tlsSock := tls.Client(sock, tlsConfig)
+		if err := tlsSock.Handshake(); err != nil {
+			dialError(ctx, err)
+			sock.Close()
+			return nil, 16
+		}
+		conn = ldap.NewConn(tlsSock, true)
+	} else {
+		conn = ldap.NewConn(sock, false)
+	}
+	conn.Start()
+	conn.SetTimeout(d.searchTimeout)
+
+	if !implicitTLS && d.startTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			dialError(ctx, err)
+			conn.Close()
+			return nil, 16
+		}
+	}
+
+	if len(d.bind) != 0 {
+		if err := conn.Bind(d.bind, d.pass); err != nil {
+			dialError(ctx, err)
+			conn.Close()
+			return nil, 17
+		}
+	}
+	sock.SetDeadline(time.Time{})
+	debugLog("Connected to LDAP server %s", server)
+	return conn, 0
+}
+
+// dialError logs failure of dial, dials cancelled because another server won the race are expected
+func dialError(ctx context.Context, err error) {
+	if ctx.Err() == context.Canceled {
+		debugLog("Dial cancelled: %s", err)
+		return
+	}
+	logger.Error(err.Error())
+}
+
+// parseServer strips optional ldap:// or ldaps:// scheme from server address,
+// address without port gets default port of scheme
+func parseServer(server string) (addr string, implicitTLS bool) {
+	port := "389"
+	switch {
+	case strings.HasPrefix(server, "ldaps://"):
+		addr, implicitTLS, port = strings.TrimPrefix(server, "ldaps://"), true, "636"
+	case strings.HasPrefix(server, "ldap://"):
+		addr = strings.TrimPrefix(server, "ldap://")
+	default:
+		addr = server
+	}
+	addr = strings.TrimSuffix(addr, "/")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]"), port)
+	}
+	return
+}
+
+func connDeadline(ctx context.Context, timeout time.Duration) (deadline time.Time) {
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	return
+}
+
+// ldapSearch performs search request bounded by search timeout and lookup deadline
+func (s *ldapSource) ldapSearch(ctx context.Context, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	sr, err := searchConn(ctx, s.conn, req)
+	if err != nil || !s.cfg.GetLdapReferrals() || len(sr.Referrals) == 0 {
+		return sr, err
+	}
+	return s.chaseReferrals(ctx, req, sr, 1, map[string]bool{})
+}
+
+func searchConn(ctx context.Context, conn ldap.Client, req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	type result struct {
+		sr  *ldap.SearchResult
+		err error
+	}
+
+	searchCtx := ctx
+	if timeout := config.GetLdapSearchTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		searchCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+		// Ask server to give up too, time limit is in seconds
+		req.TimeLimit = int((timeout + time.Second - 1) / time.Second)
+	}
+	if limit := config.GetLdapTimeLimit(); limit > 0 {
+		req.TimeLimit = int((limit + time.Second - 1) / time.Second)
+	}
+	req.SizeLimit = config.GetLdapSizeLimit()
+
+	done := make(chan result, 1)
+	pageSize := config.GetLdapPageSize()
+	go func() {
+		var res result
+		if pageSize > 0 {
+			res.sr, res.err = conn.SearchWithPaging(req, pageSize)
+		} else {
+			res.sr, res.err = conn.Search(req)
+		}
+		done <- res
+	}()
+
+	select {
+	case res := <-done:
+		switch {
+		case ldap.IsErrorWithCode(res.err, ldap.LDAPResultSizeLimitExceeded):
+			// Never use partial results, they may miss entries that deny or grant access
+			return nil, errors.New(strCat("Size limit exceeded while searching ", req.Filter, " in ", req.BaseDN))
+		case ldap.IsErrorWithCode(res.err, ldap.LDAPResultReferral):
+			// LDAP client doesn't expose URLs of referral result, only continuation references can be followed
+			return nil, errors.New(strCat("Server returned referral instead of result while searching ", req.Filter, " in ", req.BaseDN,
+				", base DN is probably in another partition"))
+		case ldap.IsErrorWithCode(res.err, ldap.LDAPResultTimeLimitExceeded):
+			return nil, errors.New(strCat("Time limit exceeded while searching ", req.Filter, " in ", req.BaseDN))
+		case res.err != nil:
+			return nil, res.err
+		}
+		return res.sr, nil
+	case <-searchCtx.Done():
+		exitOnDeadline(ctx)
+		return nil, ldap.NewError(ldap.LDAPResultTimeLimitExceeded, searchCtx.Err())
+	}
+}
+
+// watchDeadline terminates keyreader when lookup deadline expires,
+// even if it's stuck somewhere outside of LDAP client (e.g. in libc)
+func watchDeadline(ctx context.Context) {
+	<-ctx.Done()
+	exitOnDeadline(ctx)
+}
+
+func exitOnDeadline(ctx context.Context) {
+	if ctx.Err() == context.DeadlineExceeded {
+		logger.Error("Lookup deadline exceeded, aborting")
+		os.Exit(21)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+// ClientTest answers searches with predefined results, other methods panic
+type ClientTest struct {
+	ldap.Client
+	search func(*ldap.SearchRequest) (*ldap.SearchResult, error)
+	paged  uint32
+}
+
+func (ct *ClientTest) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	return ct.search(req)
+}
+
+func (ct *ClientTest) Close() {}
+
+func (ct *ClientTest) SearchWithPaging(req *ldap.SearchRequest, pageSize uint32) (*ldap.SearchResult, error) {
+	ct.paged = pageSize
+	return ct.search(req)
+}
+
+func TestLdapSearch(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		client = &ClientTest{}
+		cfg    = &ConfigV3{}
+		req    = ldap.NewSearchRequest(
+			"ou=users,dc=example,dc=com",
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			"(uid=user)", []string{"uid"}, nil,
+		)
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	src := newLdapSource(cfg, client)
+
+	client.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("uid=user", nil)}}, nil
+	}
+	sr, err := src.ldapSearch(context.Background(), req)
+	assert.NoError(err)
+	assert.Len(sr.Entries, 1)
+	assert.Zero(client.paged)
+
+	cfg.LdapPageSize = 100
+	cfg.LdapSizeLimit = 10
+	sr, err = src.ldapSearch(context.Background(), req)
+	assert.NoError(err)
+	assert.Len(sr.Entries, 1)
+	assert.Equal(uint32(100), client.paged)
+	assert.Equal(10, req.SizeLimit)
+
+	client.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		return &ldap.SearchResult{Entries: []*ldap.Entry{ldap.NewEntry("uid=user", nil)}},
+			ldap.NewError(ldap.LDAPResultSizeLimitExceeded, errors.New("too many"))
+	}
+	sr, err = src.ldapSearch(context.Background(), req)
+	assert.Error(err)
+	assert.Nil(sr)
+}
@@ -1,19 +1,16 @@
 package main
 
 import (
-	"crypto/tls"
-	"errors"
+	"context"
 	"flag"
 	"log"
 	"log/syslog"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
 
 	u "github.com/iavael/goutil"
-	"golang.org/x/crypto/ssh"
 	"gopkg.in/ldap.v2"
 )
 
@@ -23,8 +20,6 @@ var (
 
 	config Config
 	logger *u.Logger
-
-	ldconn ldap.Client
 )
 
 func main() {
@@ -38,6 +33,7 @@ func main() {
 		os.Stderr.WriteString(os.Args[0])
 		os.Stderr.WriteString(":\n")
 		flag.PrintDefaults()
+		os.Stderr.WriteString(netgroupUsage)
 	}
 
 	flag.StringVar(&confpath, "config", configPath, "Path to config file")
@@ -59,6 +55,22 @@ func main() {
 		config = cfg.(Config)
 	}
 
+	// sshd passes single user name, so "netgroup" login can't be mistaken for subcommand
+	if flag.NArg() > 1 && flag.Arg(0) == "netgroup" {
+		os.Exit(netgroupCmd(flag.Args()[1:]))
+	}
+
+	revoked, err := loadRevocations()
+	if err != nil {
+		logger.Error("Failed to load revoked keys: %s", err)
+		os.Exit(24)
+	}
+
+	if err := loadAccessLists(); err != nil {
+		logger.Error("Failed to load local access lists: %s", err)
+		os.Exit(28)
+	}
+
 	if names := config.GetHostnames(); len(names) != 0 {
 		host.names = names
 	}
@@ -84,23 +96,69 @@ func main() {
 	}
 	user = flag.Args()[0]
 
-	if conn, code := connLdap(); code != 0 {
+	ctx, cancel := lookupContext()
+	go watchDeadline(ctx)
+
+	var (
+		keys []userKey
+		code int
+	)
+	switch {
+	case len(config.GetDirectories()) != 0:
+		keys, code = directoriesKeys(ctx, user, &host, connLdap)
+	case !ldapBacked(config.GetDirectory()):
+		dir := loadDirectory(config, nil)
+		debugLog("Using %s directory", dir)
+		keys = lookupKeys(ctx, dir, user, &host)
+	default:
+		var conn ldap.Client
+		if conn, code = connLdap(ctx, config); code == 0 {
+			defer conn.Close()
+			keys = lookupKeys(ctx, loadDirectory(config, conn), user, &host)
+		}
+	}
+	if code != 0 {
+		// Directory is unreachable, emergency keys are checked by the same policies as found ones
+		if keys = emergencyKeys(config.GetLocalOverrides().EmergencyKeys, user); len(keys) == 0 {
+			os.Exit(code)
+		}
+	}
+
+	handleSigPipe()
+
+	keys = filterRevoked(keys, revoked)
+	keys = filterOptions(keys)
+	if code != 0 && len(keys) == 0 {
 		os.Exit(code)
+	}
+	if config.GetDedupKeys() {
+		keys = normalizeKeys(keys)
+	}
+	if config.GetAnnotateKeys() {
+		keys = annotateKeys(keys)
 	} else {
-		ldconn = conn
-		defer ldconn.Close()
+		keys = annotateOwners(keys)
 	}
+	cancel()
 
-	handleSigPipe()
+	printKeys(keys)
+}
 
-	for i, key := range checkUser(user, &host) {
-		if !strings.HasSuffix(key, "\n") {
-			key = strCat(key, "\n")
-		}
-		if err := printKey(i, key); err != nil {
-			logger.Warn(err.Error())
-		}
+// loadDirectory returns directory backend of cfg, keyreader can't go on without it
+func loadDirectory(cfg Config, conn ldap.Client) Directory {
+	dir, err := newDirectory(cfg, conn)
+	if err != nil {
+		logger.Error("Failed to load directory: %s", err)
+		os.Exit(29)
+	}
+	return dir
+}
+
+func lookupContext() (context.Context, context.CancelFunc) {
+	if deadline := config.GetLdapDeadline(); deadline > 0 {
+		return context.WithTimeout(context.Background(), deadline)
 	}
+	return context.WithCancel(context.Background())
 }
 
 func handleSigPipe() {
@@ -113,81 +171,149 @@ func handleSigPipe() {
 	signal.Notify(sigpipe, syscall.SIGPIPE)
 }
 
-func printKey(i int, key string) error {
-	if config.FilterByFrom() {
-		if _, _, opts, rest, err := ssh.ParseAuthorizedKey([]byte(key)); err != nil {
-			return err
-		} else if len(rest) != 0 {
-			return errors.New(strCat("SSHKey element #", strconv.Itoa(i), " has more than 1 key"))
-		} else {
-			fromFound := false
-			for _, opt := range opts {
-				if strings.HasPrefix(opt, "from=") {
-					fromFound = true
-					break
-				}
-			}
-			if !fromFound {
-				return errors.New(strCat("No host is bound to ssh key ", key))
-			}
+func printKeys(keys []userKey) {
+	for _, key := range keys {
+		line := key.line
+		if !strings.HasSuffix(line, "\n") {
+			line = strCat(line, "\n")
+		}
+		if err := printKey(line); err != nil {
+			logger.Warn(err.Error())
 		}
 	}
+}
+
+func printKey(key string) error {
 	_, err := os.Stdout.WriteString(key)
 	debugLog("Key printed!")
 	return err
 }
 
-func checkGroup(user string, host *Host) bool {
-	debugLog("Check groups permissions")
+// lookupKeys returns keys of user in dir or, for role account, keys of its members
+func lookupKeys(ctx context.Context, dir Directory, user string, host *Host) []userKey {
+	members := roleMembers(ctx, dir, user)
+	if len(members) == 0 {
+		return userKeys(ctx, dir, user, host)
+	}
+	logger.Info("User %s is role account with %d members", user, len(members))
+	if _, denied := localAccess(ctx, dir, user); denied {
+		return nil
+	}
+	var keys []userKey
+	for _, member := range members {
+		for _, key := range userKeys(ctx, dir, member, host) {
+			key.owner = member
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
 
-	grpReq := ldap.NewSearchRequest(
-		config.GetLdapGroups(),
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		grpFilter(user, host.names),
-		[]string{"trustModel", "accessTo"},
-		nil,
-	)
+// userKeys returns keys of user authorized to access host with key policy and group options applied
+func userKeys(ctx context.Context, dir Directory, user string, host *Host) []userKey {
+	var groups []*ldap.Entry
+	keys := checkUser(ctx, dir, user, host)
+	if len(keys) != 0 && (len(config.GetKeyPolicy().RequireSKGroups) != 0 || config.GetKeyOptions().enabled()) {
+		groups = memberGroups(ctx, dir, user)
+	}
+	keys = filterKeys(keys, config.GetKeyPolicy().needSK(groupNames(groups)))
+	return injectOptions(keys, config.GetKeyOptions().groupOptions(groups))
+}
+
+func checkGroup(ctx context.Context, dir Directory, user string, host *Host) (bool, string) {
+	debugLog("Check groups permissions")
 
-	if sr, err := ldconn.Search(grpReq); err != nil {
+	if groups, err := dir.findGroups(ctx, user, host.names, []string{"trustModel", "accessTo"}); err != nil {
 		logger.Error(err.Error())
 		os.Exit(18)
 	} else {
-		if len(sr.Entries) > 0 {
-			if checkAccess(user, host, sr.Entries) {
+		if len(groups) > 0 {
+			if ok, reason := checkAccess(ctx, dir, user, host, groups); ok {
 				// Just get keys, don't check user's accessTo
 				logger.Info("Access granted to user %s by group permissions", user)
-				return true
+				return true, reason
 			}
 		} else {
 			debugLog("LDAP check user group:\tno entries")
 		}
 	}
-	return false
+	return false, ""
 }
 
-func checkUser(user string, host *Host) []string {
+// memberGroups returns posix groups of dir user is member of
+func memberGroups(ctx context.Context, dir Directory, user string) []*ldap.Entry {
+	attrs := []string{"cn"}
+	if attr := config.GetKeyOptions().Attribute; len(attr) != 0 {
+		attrs = append(attrs, attr)
+	}
+	groups, err := dir.findGroups(ctx, user, nil, attrs)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(23)
+	}
+	return groups
+}
+
+func groupNames(groups []*ldap.Entry) (names []string) {
+	for _, group := range groups {
+		names = append(names, group.GetAttributeValues("cn")...)
+	}
+	return
+}
+
+func checkUser(ctx context.Context, dir Directory, user string, host *Host) []userKey {
 	// Don't check user's acl if their group has permission
 	debugLog("Check user permissions %s", user)
 
-	noUsrACL := checkGroup(user, host)
+	var (
+		noUsrACL bool
+		reason   string
+	)
+	if allowed, denied := localAccess(ctx, dir, user); denied {
+		return nil
+	} else if allowed {
+		noUsrACL, reason = true, "local allow list"
+	} else if checker, ok := dir.(accessChecker); ok {
+		granted, why, err := checker.authorize(ctx, user, host)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(30)
+		} else if !granted {
+			logger.Warn("Failed to authorize user %s", user)
+			return nil
+		}
+		noUsrACL, reason = true, why
+	} else {
+		noUsrACL, reason = checkGroup(ctx, dir, user, host)
+	}
 
 	debugLog("Will not check user's acl:\t%t", noUsrACL)
-	usrReq := ldap.NewSearchRequest(
-		config.GetLdapUsers(),
-		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
-		usrFilter(user, host.names, noUsrACL),
-		[]string{"trustModel", "accessTo", "sshPublicKey"},
-		nil,
-	)
+	attrs := []string{"trustModel", "accessTo", "sshPublicKey"}
+	if attr := config.GetKeyOptions().Attribute; len(attr) != 0 {
+		attrs = append(attrs, attr)
+	}
+	attrs = append(attrs, config.GetCertAuthority().userAttrs()...)
+	hosts := host.names
+	if noUsrACL {
+		hosts = nil
+	}
 
-	if sr, err := ldconn.Search(usrReq); err != nil {
+	if users, err := dir.findUsers(ctx, user, hosts, attrs); err != nil {
 		logger.Error(err.Error())
 		os.Exit(19)
-	} else if len(sr.Entries) > 1 {
+	} else if len(users) > 1 {
 		logger.Warn("More than 1 user with uid %s, aborting", user)
-	} else if len(sr.Entries) > 0 {
-		if noUsrACL || checkAccess(user, host, sr.Entries) {
-			return sr.Entries[0].GetAttributeValues("sshPublicKey")
+	} else if len(users) > 0 {
+		granted := noUsrACL
+		if !granted {
+			granted, reason = checkAccess(ctx, dir, user, host, users)
+		}
+		if granted {
+			keys := collectKeys(ctx, dir, users[0])
+			for i := range keys {
+				keys[i].reason = reason
+			}
+			return keys
 		} else {
 			debugLog("User %s has not access to %s", user, host)
 		}
@@ -196,42 +322,6 @@ func checkUser(user string, host *Host) []string {
 	return nil
 }
 
-func connLdap() (ldap.Client, int) {
-	var code = -1
-
-	for _, server := range config.GetLdapServers() {
-		if conn, err := ldap.Dial("tcp", server); err != nil {
-			logger.Error(err.Error())
-			code = 15
-			continue
-		} else {
-			if config.GetLdapStartTLS() {
-				if err := conn.StartTLS(&tls.Config{
-					InsecureSkipVerify: config.GetLdapIgnoreCert(),
-					ServerName:         strings.Split(server, ":")[0],
-				}); err != nil {
-					logger.Error(err.Error())
-					conn.Close()
-					code = 16
-					continue
-				}
-			}
-
-			if err := conn.Bind(
-				config.GetLdapBind(),
-				config.GetLdapPass(),
-			); err != nil {
-				logger.Error(err.Error())
-				conn.Close()
-				code = 17
-				continue
-			}
-			return conn, 0
-		}
-	}
-	return nil, code
-}
-
 func usrFilter(user string, hosts []string, noUsrAcl bool) string {
 	var filter string
 	if !noUsrAcl {
@@ -241,7 +331,11 @@ func usrFilter(user string, hosts []string, noUsrAcl bool) string {
 }
 
 func grpFilter(user string, hosts []string) string {
-	return strCat("(&(objectclass=posixGroup)(memberUid=", user, ")", aclFilter(hosts), ")")
+	var filter string
+	if hosts != nil {
+		filter = aclFilter(hosts)
+	}
+	return strCat("(&(objectclass=posixGroup)(memberUid=", user, ")", filter, ")")
 }
 
 func aclFilter(hosts []string) string {
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const (
+	netgrBackendLdap = "ldap"
+	netgrBackendNss  = "nss"
+	netgrBackendFile = "file"
+	netgrBackendDir  = "directory"
+
+	wildcardDeny = "deny"
+	wildcardAny  = "allow-any-host"
+	wildcardUser = "match-user"
+
+	tripleElem  = `(|\-|[[:alnum:]](?:[[:alnum:]\-\.]*?[[:alnum:]])?)`
+	netgrTriple = `^\(` + tripleElem + `,` + tripleElem + `,` + tripleElem + `\)$`
+)
+
+var (
+	ngMemberRegex = regexp.MustCompile(netgrTriple)
+)
+
+var errNetgroupNotFound = errors.New("no such netgroups")
+
+// NetgroupResolver checks membership of hosts (and optionally user and domain) in netgroups
+type NetgroupResolver interface {
+	inNetGroups(ctx context.Context, netgroups []string, query netgroupQuery) (bool, error)
+	String() string
+}
+
+// netgroupQuery is triple to search in netgroups, empty user or domain are not checked,
+// login is used by match-user wildcard host semantics and wildcard is semantics used
+// when netgroup_wildcard_host isn't set
+type netgroupQuery struct {
+	hosts    []string
+	user     string
+	domain   string
+	login    string
+	wildcard string
+}
+
+// netgroupGraph maps netgroup name to triples and nested netgroups
+type netgroupGraph map[string]*netgroupEntry
+
+type netgroupEntry struct {
+	triples  []string
+	children []string
+}
+
+// netgroupTriple is (host,user,domain) netgroup member, empty field is wildcard
+type netgroupTriple struct {
+	host   string
+	user   string
+	domain string
+}
+
+func (t netgroupTriple) String() string {
+	return strCat("(", t.host, ",", t.user, ",", t.domain, ")")
+}
+
+func wildcardHostValid(mode string) bool {
+	switch mode {
+	case "", wildcardDeny, wildcardAny, wildcardUser:
+		return true
+	}
+	return false
+}
+
+func netgrBackendValid(name string) bool {
+	switch name {
+	case netgrBackendLdap, netgrBackendNss, netgrBackendFile, netgrBackendDir:
+		return true
+	}
+	return false
+}
+
+// netgroupBackends returns names of configured netgroup backends in fallback order
+func netgroupBackends() []string {
+	switch {
+	case len(config.GetNetgroupBackends()) != 0:
+		return config.GetNetgroupBackends()
+	case config.GetDirectory() == dirBackendFile, config.GetDirectory() == dirBackendHTTP:
+		return []string{netgrBackendDir}
+	}
+	return defaultNetgroupBackends
+}
+
+// netgroupResolvers returns netgroup backends in fallback order, ldap and directory ones read dir
+func netgroupResolvers(dir Directory) (res []NetgroupResolver) {
+	for _, name := range netgroupBackends() {
+		switch name {
+		case netgrBackendLdap:
+			if src := dirSource(dir); src != nil {
+				res = append(res, ldapNetgroups{src})
+			}
+		case netgrBackendNss:
+			res = append(res, nssNetgroups{})
+		case netgrBackendFile:
+			res = append(res, fileNetgroups{path: config.GetNetgroupFile()})
+		case netgrBackendDir:
+			res = append(res, dir)
+		}
+	}
+	return
+}
+
+func (h Host) inNetGroups(ctx context.Context, dir Directory, user string, netgroups []string) bool {
+	if len(netgroups) == 0 {
+		return false
+	}
+	debugLog("Search host in netgroups")
+	for _, netgroup := range netgroups {
+		debugLog("Netgroup: \t%s", netgroup)
+	}
+
+	query := netgroupQuery{hosts: h.names, domain: config.GetNisDomain(), login: user}
+	if config.GetNetgroupMatchUser() {
+		query.user = user
+	}
+
+	var notFound bool
+	for _, resolver := range netgroupResolvers(dir) {
+		found, err := resolver.inNetGroups(ctx, netgroups, query)
+		switch {
+		case err == nil:
+			return found
+		case err == errNetgroupNotFound:
+			debugLog("Netgroups not found by %s backend", resolver)
+			notFound = true
+		default:
+			exitOnDeadline(ctx)
+			logger.Warn("Netgroup backend %s failed: %s", resolver, err)
+		}
+	}
+	if notFound {
+		return false
+	}
+	logger.Error("All netgroup backends failed")
+	os.Exit(20)
+	return false
+}
+
+// matchTriples returns true if any triple matches query
+func matchTriples(netgr string, triples []string, query netgroupQuery) bool {
+	for _, triple := range triples {
+		matches := ngMemberRegex.FindStringSubmatch(triple)
+		if len(matches) == 0 {
+			logger.Warn("Invalid %s triple in netgroup %s", triple, netgr)
+			continue
+		}
+		if matchTriple(netgr, netgroupTriple{host: matches[1], user: matches[2], domain: matches[3]}, query) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTriple checks triple against hosts, user and domain of query,
+// wildcard host is handled according to netgroup_wildcard_host
+func matchTriple(netgr string, triple netgroupTriple, query netgroupQuery) bool {
+	switch {
+	case !matchField(triple.user, query.user):
+		debugLog("User %s does not match triple %s of netgroup %s", query.user, triple, netgr)
+		return false
+	case !matchField(triple.domain, query.domain):
+		debugLog("Domain %s does not match triple %s of netgroup %s", query.domain, triple, netgr)
+		return false
+	case triple.host == "-":
+		logger.Warn("Undefined host in triple %s of netgroup %s", triple, netgr)
+		return false
+	case triple.host == "":
+		return matchWildcardHost(netgr, triple, query)
+	}
+	for _, host := range query.hosts {
+		// Host names are case insensitive, like in innetgr
+		if strings.EqualFold(triple.host, host) {
+			logger.Info("Found host %s in netgroup %s", host, netgr)
+			return true
+		}
+	}
+	logger.Debug("No host in triple %s of netgroup %s", triple, netgr)
+	return false
+}
+
+func matchWildcardHost(netgr string, triple netgroupTriple, query netgroupQuery) bool {
+	mode := config.GetNetgroupWildcardHost()
+	if len(mode) == 0 {
+		mode = query.wildcard
+	}
+	switch mode {
+	case wildcardAny:
+		logger.Info("Wildcard host in triple %s of netgroup %s matches any host", triple, netgr)
+		return true
+	case wildcardUser:
+		if len(triple.user) != 0 && triple.user != "-" && triple.user == query.login {
+			logger.Info("Wildcard host in triple %s of netgroup %s matches user %s", triple, netgr, query.login)
+			return true
+		}
+		debugLog("Wildcard host in triple %s of netgroup %s does not match user %s", triple, netgr, query.login)
+		return false
+	}
+	logger.Warn("Wildcard host in triple %s of netgroup %s", triple, netgr)
+	return false
+}
+
+// matchField matches user or domain field of triple, empty field is wildcard and - matches nothing
+func matchField(field, value string) bool {
+	switch {
+	case len(value) == 0, len(field) == 0:
+		return true
+	case field == "-":
+		return false
+	}
+	return field == value
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	u "github.com/iavael/goutil"
+	"gopkg.in/ldap.v2"
+)
+
+const netgroupUsage = `Usage of netgroup command:
+  keyreader netgroup show <name>	print netgroup hierarchy as tree
+  keyreader netgroup lint		report cycles, missing netgroups, invalid triples and unresolvable hosts
+`
+
+// netgroupCmd runs netgroup diagnostics subcommand and returns exit code
+func netgroupCmd(args []string) int {
+	switch {
+	case len(args) == 2 && args[0] == "show":
+	case len(args) == 1 && args[0] == "lint":
+	default:
+		os.Stderr.WriteString(netgroupUsage)
+		return 2
+	}
+
+	if err := netgroupCmdSupported(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	ctx := context.Background()
+	conn, code := connLdap(ctx, config)
+	if code != 0 {
+		return code
+	}
+	defer conn.Close()
+
+	graph, err := loadNetgroupGraph(ctx, newLdapSource(config, conn))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read netgroups: %s\n", err)
+		return 20
+	}
+
+	switch args[0] {
+	case "show":
+		if err := graph.show(os.Stdout, args[1]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case "lint":
+		if graph.lint(ctx, os.Stdout, resolveHost) != 0 {
+			return 1
+		}
+	}
+	return 0
+}
+
+// netgroupCmdSupported rejects configs whose netgroups don't come from single LDAP directory,
+// netgroup command reads ldap_base_netgrs only
+func netgroupCmdSupported() error {
+	switch {
+	case len(config.GetDirectories()) != 0:
+		return errors.New("Netgroup command doesn't support named directories")
+	case !ldapBacked(config.GetDirectory()):
+		return fmt.Errorf("Netgroup command needs ldap directory, %s directory is configured", config.GetDirectory())
+	}
+	// Backends may come from build default, so they're not taken from config directly
+	if backends := netgroupBackends(); !u.MemberOfSlice(netgrBackendLdap, backends) {
+		return fmt.Errorf("Netgroup command needs ldap netgroup backend, %s backends are used", strings.Join(backends, ", "))
+	}
+	return nil
+}
+
+// loadNetgroupGraph reads every netgroup from ldap_base_netgrs of src
+func loadNetgroupGraph(ctx context.Context, src *ldapSource) (netgroupGraph, error) {
+	netGroupReq := ldap.NewSearchRequest(
+		src.cfg.GetLdapNetGrs(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		"(objectClass=nisNetgroup)",
+		[]string{"cn", netgrMember, netgrChild},
+		nil,
+	)
+	sr, err := src.ldapSearch(ctx, netGroupReq)
+	if err != nil {
+		return nil, err
+	}
+	graph := netgroupGraph{}
+	for _, entry := range sr.Entries {
+		for _, cn := range entry.GetAttributeValues("cn") {
+			ng := graph[cn]
+			if ng == nil {
+				ng = &netgroupEntry{}
+				graph[cn] = ng
+			}
+			ng.triples = append(ng.triples, entry.GetAttributeValues(netgrMember)...)
+			ng.children = append(ng.children, entry.GetAttributeValues(netgrChild)...)
+		}
+	}
+	return graph, nil
+}
+
+// show prints netgroup with its triples and nested netgroups as tree
+func (g netgroupGraph) show(w io.Writer, name string) error {
+	if _, ok := g[name]; !ok {
+		return fmt.Errorf("Netgroup %s not found", name)
+	}
+	g.printTree(w, name, 0, map[string]bool{})
+	return nil
+}
+
+func (g netgroupGraph) printTree(w io.Writer, name string, depth int, path map[string]bool) {
+	indent := strings.Repeat("  ", depth)
+	fmt.Fprintf(w, "%s%s\n", indent, name)
+
+	path[name] = true
+	defer delete(path, name)
+
+	entry := g[name]
+	for _, triple := range entry.triples {
+		if ngMemberRegex.MatchString(triple) {
+			fmt.Fprintf(w, "%s  %s\n", indent, triple)
+		} else {
+			fmt.Fprintf(w, "%s  %s (invalid)\n", indent, triple)
+		}
+	}
+	for _, child := range entry.children {
+		switch {
+		case path[child]:
+			fmt.Fprintf(w, "%s  %s (cycle)\n", indent, child)
+		case g[child] == nil:
+			fmt.Fprintf(w, "%s  %s (missing)\n", indent, child)
+		default:
+			g.printTree(w, child, depth+1, path)
+		}
+	}
+}
+
+// lint reports problems of netgroup graph and returns their count
+func (g netgroupGraph) lint(ctx context.Context, w io.Writer, resolve func(context.Context, string) error) (problems int) {
+	var (
+		names []string
+		hosts = map[string][]string{}
+	)
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, triple := range g[name].triples {
+			matches := ngMemberRegex.FindStringSubmatch(triple)
+			if len(matches) == 0 {
+				fmt.Fprintf(w, "netgroup %s: invalid triple %s\n", name, triple)
+				problems++
+				continue
+			}
+			if host := matches[1]; len(host) != 0 && host != "-" {
+				hosts[host] = append(hosts[host], name)
+			}
+		}
+		for _, child := range g[name].children {
+			if g[child] == nil {
+				fmt.Fprintf(w, "netgroup %s: missing member netgroup %s\n", name, child)
+				problems++
+			}
+		}
+	}
+
+	for _, cycle := range g.cycles(names) {
+		fmt.Fprintf(w, "cycle: %s\n", strings.Join(cycle, " -> "))
+		problems++
+	}
+
+	var hostnames []string
+	for host := range hosts {
+		hostnames = append(hostnames, host)
+	}
+	sort.Strings(hostnames)
+	for _, host := range hostnames {
+		if err := resolve(ctx, host); err != nil {
+			fmt.Fprintf(w, "netgroup %s: host %s is unresolvable: %s\n", strings.Join(hosts[host], ","), host, err)
+			problems++
+		}
+	}
+	return
+}
+
+// cycles returns every cycle of nested netgroups once, starting from its first visited netgroup
+func (g netgroupGraph) cycles(names []string) (res [][]string) {
+	const (
+		unvisited = iota
+		inPath
+		done
+	)
+	var (
+		state = map[string]int{}
+		path  []string
+		visit func(string)
+	)
+	visit = func(name string) {
+		state[name] = inPath
+		path = append(path, name)
+		for _, child := range g[name].children {
+			switch {
+			case g[child] == nil:
+			case state[child] == inPath:
+				for i := range path {
+					if path[i] == child {
+						cycle := append(append([]string(nil), path[i:]...), child)
+						res = append(res, cycle)
+						break
+					}
+				}
+			case state[child] == unvisited:
+				visit(child)
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = done
+	}
+	for _, name := range names {
+		if state[name] == unvisited {
+			visit(name)
+		}
+	}
+	return
+}
+
+// resolveHost checks that host name resolves, it doesn't try to connect to host
+func resolveHost(ctx context.Context, host string) error {
+	if timeout := config.GetLdapConnTimeout(); timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	_, err := net.DefaultResolver.LookupHost(ctx, host)
+	return err
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+func TestNetgroupCmd(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		out    bytes.Buffer
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapNetGrs = "ou=netgroups"
+	conn := &ClientTest{search: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		assert.Equal("(objectClass=nisNetgroup)", req.Filter)
+		return &ldap.SearchResult{Entries: []*ldap.Entry{
+			ldap.NewEntry("cn=servers,ou=netgroups", map[string][]string{
+				"cn":        {"servers"},
+				netgrMember: {"(web1.example.com,-,)", "(web 2,-,)"},
+				netgrChild:  {"databases", "printers"},
+			}),
+			ldap.NewEntry("cn=databases,ou=netgroups", map[string][]string{
+				"cn":        {"databases"},
+				netgrMember: {"(db1.example.com,-,)"},
+				netgrChild:  {"servers"},
+			}),
+			ldap.NewEntry("cn=admins,ou=netgroups", map[string][]string{
+				"cn":        {"admins"},
+				netgrMember: {"(,alice,)"},
+			}),
+		}}, nil
+	}}
+
+	graph, err := loadNetgroupGraph(context.Background(), newLdapSource(cfg, conn))
+	assert.NoError(err)
+	assert.Len(graph, 3)
+
+	assert.NoError(graph.show(&out, "servers"))
+	assert.Equal(strings.Join([]string{
+		"servers",
+		"  (web1.example.com,-,)",
+		"  (web 2,-,) (invalid)",
+		"  databases",
+		"    (db1.example.com,-,)",
+		"    servers (cycle)",
+		"  printers (missing)",
+		"",
+	}, "\n"), out.String())
+	assert.Error(graph.show(&out, "missing"))
+
+	out.Reset()
+	resolve := func(_ context.Context, host string) error {
+		if host == "db1.example.com" {
+			return errors.New("no such host")
+		}
+		return nil
+	}
+	assert.Equal(4, graph.lint(context.Background(), &out, resolve))
+	assert.Equal(strings.Join([]string{
+		"netgroup servers: invalid triple (web 2,-,)",
+		"netgroup servers: missing member netgroup printers",
+		"cycle: databases -> servers -> databases",
+		"netgroup databases: host db1.example.com is unresolvable: no such host",
+		"",
+	}, "\n"), out.String())
+
+	assert.Equal(2, netgroupCmd([]string{"show"}))
+	assert.Equal(2, netgroupCmd([]string{"lint", "servers"}))
+
+	// Without netgroup_backends build default is used
+	if u.MemberOfSlice(netgrBackendLdap, defaultNetgroupBackends) {
+		assert.NoError(netgroupCmdSupported())
+	} else {
+		assert.EqualError(netgroupCmdSupported(), "Netgroup command needs ldap netgroup backend, nss backends are used")
+	}
+	cfg.NetgroupBackends = []string{netgrBackendNss, netgrBackendLdap}
+	assert.NoError(netgroupCmdSupported())
+	cfg.NetgroupBackends = []string{netgrBackendFile}
+	assert.EqualError(netgroupCmdSupported(), "Netgroup command needs ldap netgroup backend, file backends are used")
+	assert.Equal(2, netgroupCmd([]string{"lint"}))
+	cfg.NetgroupBackends = nil
+	cfg.Directory = dirBackendHTTP
+	assert.EqualError(netgroupCmdSupported(), "Netgroup command needs ldap directory, http directory is configured")
+	cfg.Directory = ""
+	cfg.Directories = []NamedDirectory{{Name: "legacy"}}
+	assert.Error(netgroupCmdSupported())
+	cfg.Directories = nil
+}
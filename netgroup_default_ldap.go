@@ -0,0 +1,5 @@
+// +build ldap,!libc !cgo,!libc freebsd,!libc
+
+package main
+
+var defaultNetgroupBackends = []string{netgrBackendLdap}
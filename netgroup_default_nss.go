@@ -0,0 +1,5 @@
+// +build libc,!ldap cgo,!freebsd,!ldap
+
+package main
+
+var defaultNetgroupBackends = []string{netgrBackendNss}
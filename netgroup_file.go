@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// fileNetgroups reads netgroups from /etc/netgroup formatted file
+type fileNetgroups struct {
+	path string
+}
+
+func (f fileNetgroups) String() string {
+	return netgrBackendFile
+}
+
+func (f fileNetgroups) inNetGroups(ctx context.Context, netgroups []string, query netgroupQuery) (bool, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	nf, err := parseNetgroupFile(file)
+	if err != nil {
+		return false, err
+	}
+	return nf.inNetGroups(netgroups, query)
+}
+
+func (nf netgroupGraph) inNetGroups(netgroups []string, query netgroupQuery) (bool, error) {
+	var (
+		looptest = map[string]bool{}
+		nextgrps = append([]string(nil), netgroups...)
+		found    bool
+	)
+	for _, grp := range nextgrps {
+		looptest[grp] = true
+	}
+
+	for len(nextgrps) > 0 {
+		var netgr string
+		netgr, nextgrps = nextgrps[0], nextgrps[1:]
+		entry, ok := nf[netgr]
+		if !ok {
+			debugLog("Netgroup %s not found in file", netgr)
+			continue
+		}
+		found = true
+		if matchTriples(netgr, entry.triples, query) {
+			return true, nil
+		}
+		for _, child := range entry.children {
+			if looptest[child] {
+				logger.Warn("Detected loop on netgroup %s", netgr)
+				continue
+			}
+			looptest[child] = true
+			nextgrps = append(nextgrps, child)
+		}
+	}
+	if !found {
+		return false, errNetgroupNotFound
+	}
+	return false, nil
+}
+
+// parseNetgroupFile parses netgroup(5) file: name followed by (host,user,domain) triples and
+// names of nested netgroups, # starts comment and backslash continues line
+func parseNetgroupFile(r io.Reader) (netgroupGraph, error) {
+	var (
+		nf      = netgroupGraph{}
+		scanner = bufio.NewScanner(r)
+		line    string
+	)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if i := strings.IndexByte(text, '#'); i >= 0 {
+			text = text[:i]
+		}
+		text = strings.TrimRightFunc(text, unicode.IsSpace)
+		if strings.HasSuffix(text, "\\") {
+			line = strCat(line, text[:len(text)-1], " ")
+			continue
+		}
+		line = strCat(line, text)
+
+		tokens := netgroupTokens(line)
+		line = ""
+		if len(tokens) == 0 {
+			continue
+		}
+		entry, ok := nf[tokens[0]]
+		if !ok {
+			entry = &netgroupEntry{}
+			nf[tokens[0]] = entry
+		}
+		for _, token := range tokens[1:] {
+			if strings.HasPrefix(token, "(") {
+				entry.triples = append(entry.triples, token)
+			} else {
+				entry.children = append(entry.children, token)
+			}
+		}
+	}
+	return nf, scanner.Err()
+}
+
+// netgroupTokens splits line by whitespace, spaces inside triples are dropped
+func netgroupTokens(line string) (tokens []string) {
+	var (
+		token   strings.Builder
+		inTuple bool
+	)
+	for _, c := range line {
+		switch {
+		case c == '(' && token.Len() == 0:
+			inTuple = true
+			token.WriteRune(c)
+		case c == ')' && inTuple:
+			inTuple = false
+			token.WriteRune(c)
+		case unicode.IsSpace(c):
+			if !inTuple && token.Len() != 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(c)
+		}
+	}
+	if token.Len() != 0 {
+		tokens = append(tokens, token.String())
+	}
+	return
+}
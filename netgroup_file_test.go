@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileNetgroups(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		resolver = fileNetgroups{path: filepath.Join("testdata", "netgroup")}
+		web1     = []string{"web1.example.com"}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+
+	check := func(netgroup string, query netgroupQuery) bool {
+		found, err := resolver.inNetGroups(context.Background(), []string{netgroup}, query)
+		assert.NoError(err)
+		return found
+	}
+
+	assert.True(check("servers", netgroupQuery{hosts: web1}))
+	assert.True(check("servers", netgroupQuery{hosts: []string{"web2.example.com"}}))
+	assert.True(check("servers", netgroupQuery{hosts: []string{"db1.example.com"}}))
+	assert.False(check("servers", netgroupQuery{hosts: []string{"db3.example.com"}}))
+	assert.False(check("servers", netgroupQuery{hosts: web1, user: "alice"}))
+
+	assert.True(check("databases", netgroupQuery{hosts: []string{"db1.example.com"}, domain: "example"}))
+	assert.False(check("databases", netgroupQuery{hosts: []string{"db2.example.com"}, domain: "example"}))
+
+	assert.True(check("ops", netgroupQuery{hosts: web1, user: "carol"}))
+	assert.False(check("ops", netgroupQuery{hosts: web1, user: "alice"}))
+	assert.False(check("wildcard", netgroupQuery{hosts: web1}))
+
+	assert.True(check("loop1", netgroupQuery{hosts: web1}))
+	assert.False(check("loop1", netgroupQuery{hosts: []string{"other.example.com"}}))
+
+	_, err := resolver.inNetGroups(context.Background(), []string{"missing"}, netgroupQuery{hosts: web1})
+	assert.Equal(errNetgroupNotFound, err)
+
+	_, err = fileNetgroups{path: filepath.Join("testdata", "missing")}.inNetGroups(context.Background(), []string{"servers"}, netgroupQuery{hosts: web1})
+	assert.Error(err)
+
+	assert.Equal([]string{"ng", "(h,u,d)", "child"}, netgroupTokens("ng\t( h , u , d )  child"))
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	netgrMember = "nisNetgroupTriple"
+	netgrChild  = "memberNisNetgroup"
+)
+
+// NetgroupLdap tunes expansion of nested netgroups in LDAP backend. Parallel limits concurrent searches
+// of batches within one level, levels are fetched one after another as children are known only from parents
+type NetgroupLdap struct {
+	BatchSize int  `yaml:"batch_size"`
+	Parallel  int  `yaml:"parallel"`
+	MaxDepth  int  `yaml:"max_depth"`
+	Cache     bool `yaml:"cache"`
+}
+
+// Check function validates LDAP netgroup backend settings
+func (n *NetgroupLdap) Check() error {
+	switch {
+	case n.BatchSize < 0:
+		return errors.New("Negative netgroup batch size")
+	case n.Parallel < 0:
+		return errors.New("Negative netgroup parallel searches count")
+	case n.MaxDepth < 0:
+		return errors.New("Negative netgroup depth limit")
+	}
+	return nil
+}
+
+// netgroupCache holds netgroups fetched by this process from one directory, nil entry means netgroup does not exist
+type netgroupCache struct {
+	sync.Mutex
+	entries map[string]*netgroupEntry
+}
+
+// ldapNetgroups reads nisNetgroup entries from ldap_base_netgrs of source
+type ldapNetgroups struct {
+	*ldapSource
+}
+
+func (ldapNetgroups) String() string {
+	return netgrBackendLdap
+}
+
+// inNetGroups expands netgroups breadth-first, fetching every level with batched OR filters
+func (n ldapNetgroups) inNetGroups(ctx context.Context, netgroups []string, query netgroupQuery) (bool, error) {
+	var (
+		settings = config.GetNetgroupLdap()
+		looptest = map[string]bool{}
+		level    []string
+		found    bool
+	)
+
+	for _, grp := range netgroups {
+		if !looptest[grp] {
+			looptest[grp] = true
+			level = append(level, grp)
+		}
+	}
+
+	for depth := 0; len(level) > 0; depth++ {
+		if settings.MaxDepth > 0 && depth >= settings.MaxDepth {
+			logger.Warn("Netgroup depth limit %d reached, skipping %d nested netgroups", settings.MaxDepth, len(level))
+			break
+		}
+		entries, err := n.fetchNetgroups(ctx, level)
+		if err != nil {
+			return false, err
+		}
+		var next []string
+		for _, netgr := range level {
+			entry := entries[strings.ToLower(netgr)]
+			if entry == nil {
+				debugLog("Netgroup %s not found", netgr)
+				continue
+			}
+			found = true
+			if matchTriples(netgr, entry.triples, query) {
+				return true, nil
+			}
+			for _, child := range entry.children {
+				if looptest[child] {
+					debugLog("Netgroup %s was already visited", child)
+					continue
+				}
+				looptest[child] = true
+				next = append(next, child)
+			}
+		}
+		level = next
+	}
+	if !found {
+		return false, errNetgroupNotFound
+	}
+	return false, nil
+}
+
+// fetchNetgroups returns netgroups of one level by lowercased name, taking them from cache when enabled,
+// batches of missing netgroups are searched concurrently
+func (n ldapNetgroups) fetchNetgroups(ctx context.Context, names []string) (map[string]*netgroupEntry, error) {
+	var (
+		settings = config.GetNetgroupLdap()
+		res      = map[string]*netgroupEntry{}
+		missing  []string
+	)
+
+	n.netgroups.Lock()
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if entry, ok := n.netgroups.entries[key]; ok && settings.Cache {
+			res[key] = entry
+		} else {
+			missing = append(missing, name)
+		}
+	}
+	n.netgroups.Unlock()
+	if len(missing) == 0 {
+		return res, nil
+	}
+
+	var batches [][]string
+	batchSize := settings.BatchSize
+	if batchSize == 0 {
+		batchSize = len(missing)
+	}
+	for len(missing) > batchSize {
+		batches = append(batches, missing[:batchSize])
+		missing = missing[batchSize:]
+	}
+	batches = append(batches, missing)
+
+	var (
+		wg      sync.WaitGroup
+		mutex   sync.Mutex
+		lastErr error
+		sem     = make(chan struct{}, maxInt(settings.Parallel, 1))
+	)
+	for _, batch := range batches {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(batch []string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			entries, err := n.searchNetgroups(ctx, batch)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				lastErr = err
+				return
+			}
+			for key, entry := range entries {
+				res[key] = entry
+			}
+		}(batch)
+	}
+	wg.Wait()
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	if settings.Cache {
+		n.netgroups.Lock()
+		for _, name := range names {
+			key := strings.ToLower(name)
+			n.netgroups.entries[key] = res[key]
+		}
+		n.netgroups.Unlock()
+	}
+	return res, nil
+}
+
+// searchNetgroups fetches batch of netgroups with single OR filter
+func (n ldapNetgroups) searchNetgroups(ctx context.Context, names []string) (map[string]*netgroupEntry, error) {
+	var (
+		filters []string
+		wanted  = map[string]bool{}
+		res     = map[string]*netgroupEntry{}
+	)
+	for _, name := range names {
+		filters = append(filters, strCat("(cn=", ldap.EscapeFilter(name), ")"))
+		wanted[strings.ToLower(name)] = true
+	}
+	filter := filters[0]
+	if len(filters) > 1 {
+		filter = strCat("(|", strCat(filters...), ")")
+	}
+	netGroupReq := ldap.NewSearchRequest(
+		n.cfg.GetLdapNetGrs(),
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+		filter,
+		[]string{"cn", netgrMember, netgrChild},
+		nil,
+	)
+	sr, err := n.ldapSearch(ctx, netGroupReq)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range sr.Entries {
+		for _, cn := range entry.GetAttributeValues("cn") {
+			key := strings.ToLower(cn)
+			if !wanted[key] {
+				continue
+			}
+			ng := res[key]
+			if ng == nil {
+				ng = &netgroupEntry{}
+				res[key] = ng
+			}
+			ng.triples = append(ng.triples, entry.GetAttributeValues(netgrMember)...)
+			ng.children = append(ng.children, entry.GetAttributeValues(netgrChild)...)
+		}
+	}
+	return res, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+func TestLdapNetgroupExpansion(t *testing.T) {
+	var (
+		assert  = assert.New(t)
+		cfg     = &ConfigV3{}
+		mutex   sync.Mutex
+		filters []string
+		tree    = map[string][]string{
+			"top":  {"a", "b", "c"},
+			"a":    {"leaf", "top"},
+			"b":    {"leaf"},
+			"c":    {"deep"},
+			"deep": {"leaf"},
+		}
+		query = netgroupQuery{hosts: []string{"host.example.com"}}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapNetGrs = "ou=netgroups"
+	cfg.NetgroupLdap = NetgroupLdap{BatchSize: 2, Parallel: 2}
+	conn := &ClientTest{search: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		mutex.Lock()
+		filters = append(filters, req.Filter)
+		mutex.Unlock()
+		sr := &ldap.SearchResult{}
+		for name, children := range tree {
+			if strings.Contains(req.Filter, strCat("(cn=", name, ")")) {
+				sr.Entries = append(sr.Entries, ldap.NewEntry(strCat("cn=", name, ",ou=netgroups"), map[string][]string{
+					"cn":       {strings.ToUpper(name)},
+					netgrChild: children,
+				}))
+			}
+		}
+		if strings.Contains(req.Filter, "(cn=leaf)") {
+			sr.Entries = append(sr.Entries, ldap.NewEntry("cn=leaf,ou=netgroups", map[string][]string{
+				"cn":        {"leaf"},
+				netgrMember: {"(host.example.com,-,)"},
+			}))
+		}
+		return sr, nil
+	}}
+	netgroups := ldapNetgroups{newLdapSource(cfg, conn)}
+
+	found, err := netgroups.inNetGroups(context.Background(), []string{"top"}, query)
+	assert.NoError(err)
+	assert.True(found)
+	assert.Equal("(cn=top)", filters[0])
+	assert.Len(filters, 4)
+	assert.Contains(filters[1:3], "(|(cn=a)(cn=b))")
+	assert.Contains(filters[1:3], "(cn=c)")
+	assert.Equal("(|(cn=leaf)(cn=deep))", filters[3])
+
+	filters = nil
+	cfg.NetgroupLdap.MaxDepth = 2
+	found, err = netgroups.inNetGroups(context.Background(), []string{"top"}, query)
+	assert.NoError(err)
+	assert.False(found)
+	assert.Len(filters, 3)
+
+	filters = nil
+	cfg.NetgroupLdap = NetgroupLdap{Cache: true}
+	found, _ = netgroups.inNetGroups(context.Background(), []string{"c"}, query)
+	assert.True(found)
+	assert.Equal([]string{"(cn=c)", "(cn=deep)", "(cn=leaf)"}, filters)
+	found, _ = netgroups.inNetGroups(context.Background(), []string{"c", "missing"}, query)
+	assert.True(found)
+	assert.Equal([]string{"(cn=c)", "(cn=deep)", "(cn=leaf)", "(cn=missing)"}, filters)
+	_, err = netgroups.inNetGroups(context.Background(), []string{"missing"}, query)
+	assert.Equal(errNetgroupNotFound, err)
+	assert.Len(filters, 4)
+}
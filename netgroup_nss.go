@@ -0,0 +1,74 @@
+// +build cgo
+
+package main
+
+/*
+#cgo LDFLAGS: -lc
+#include <stdlib.h>
+#include <netdb.h>
+*/
+import "C"
+
+import (
+	"context"
+	"unsafe"
+)
+
+// nssNetgroups enumerates netgroups with libc getnetgrent (files, nis, sssd etc. via nsswitch),
+// unless netgroup_wildcard_host is set triples are matched the way innetgr does: wildcard host matches any host
+type nssNetgroups struct{}
+
+func (nssNetgroups) String() string {
+	return netgrBackendNss
+}
+
+func (nssNetgroups) inNetGroups(ctx context.Context, netgroups []string, query netgroupQuery) (bool, error) {
+	var found bool
+	query.wildcard = wildcardAny
+	for _, netgroup := range netgroups {
+		exitOnDeadline(ctx)
+		logger.Debug("Checking hosts for membership in %s", netgroup)
+		triples := nssNetgroupTriples(netgroup)
+		if len(triples) != 0 {
+			found = true
+		}
+		for _, triple := range triples {
+			if matchTriple(netgroup, triple, query) {
+				return true, nil
+			}
+		}
+	}
+	if !found {
+		return false, errNetgroupNotFound
+	}
+	return false, nil
+}
+
+// nssNetgroupTriples returns triples of netgroup with nested netgroups expanded by libc
+func nssNetgroupTriples(netgroup string) (triples []netgroupTriple) {
+	var (
+		chost   *C.char
+		cuser   *C.char
+		cdomain *C.char
+	)
+	cnetgr := C.CString(netgroup)
+	defer C.free(unsafe.Pointer(cnetgr))
+
+	C.setnetgrent(cnetgr)
+	defer C.endnetgrent()
+	for C.getnetgrent(&chost, &cuser, &cdomain) != 0 {
+		triples = append(triples, netgroupTriple{
+			host:   nssField(chost),
+			user:   nssField(cuser),
+			domain: nssField(cdomain),
+		})
+	}
+	return
+}
+
+func nssField(field *C.char) string {
+	if field == nil {
+		return ""
+	}
+	return C.GoString(field)
+}
@@ -0,0 +1,19 @@
+// +build !cgo
+
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// nssNetgroups is unavailable without cgo
+type nssNetgroups struct{}
+
+func (nssNetgroups) String() string {
+	return netgrBackendNss
+}
+
+func (nssNetgroups) inNetGroups(_ context.Context, _ []string, _ netgroupQuery) (bool, error) {
+	return false, errors.New("nss netgroups are not supported by this build (cgo disabled)")
+}
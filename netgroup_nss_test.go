@@ -0,0 +1,24 @@
+// +build cgo
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNssNetgroups(t *testing.T) {
+	assert := assert.New(t)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = &ConfigV3{}
+
+	// Missing netgroup lets fallback chain go on to the next backend
+	found, err := nssNetgroups{}.inNetGroups(context.Background(), []string{"keyreader-no-such-netgroup"},
+		netgroupQuery{hosts: []string{"host.example.com"}})
+	assert.False(found)
+	assert.Equal(errNetgroupNotFound, err)
+}
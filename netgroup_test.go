@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+func TestNetgroupResolvers(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		host   = Host{names: []string{"host.example.com"}}
+		calls  int
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapNetGrs = "ou=netgroups"
+	conn := &ClientTest{}
+	dir, err := newDirectory(cfg, conn)
+	assert.NoError(err)
+
+	assert.Equal(defaultNetgroupBackends, []string{netgroupResolvers(dir)[0].String()})
+
+	cfg.NetgroupBackends = []string{"ldap", "nss"}
+	netgroups := ldapNetgroups{dirSource(dir)}
+	assert.Equal([]NetgroupResolver{netgroups, nssNetgroups{}}, netgroupResolvers(dir))
+
+	conn.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		calls++
+		assert.Equal("(cn=servers)", req.Filter)
+		return &ldap.SearchResult{Entries: []*ldap.Entry{
+			ldap.NewEntry("cn=servers,ou=netgroups", map[string][]string{
+				"cn":        {"servers"},
+				netgrMember: {"(host.example.com,-,)"},
+			}),
+		}}, nil
+	}
+	assert.True(host.inNetGroups(context.Background(), dir, "user", []string{"servers"}))
+	assert.False(host.inNetGroups(context.Background(), dir, "user", nil))
+	assert.Equal(1, calls)
+
+	conn.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		return &ldap.SearchResult{}, nil
+	}
+	found, err := netgroups.inNetGroups(context.Background(), []string{"servers"}, netgroupQuery{hosts: host.names})
+	assert.False(found)
+	assert.Equal(errNetgroupNotFound, err)
+	assert.False(host.inNetGroups(context.Background(), dir, "user", []string{"servers"}))
+
+	conn.search = func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		return nil, errors.New("server is down")
+	}
+	_, err = netgroups.inNetGroups(context.Background(), []string{"servers"}, netgroupQuery{hosts: host.names})
+	assert.Error(err)
+
+	cfg.LdapServers = []string{"ldap.example.com"}
+	cfg.NetgroupBackends = []string{"ldap", "yp"}
+	assert.EqualError(cfg.Check(), "Invalid netgroup backend yp, need ldap, nss, file or directory")
+}
+
+func TestMatchTriples(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		hosts  = []string{"host.example.com"}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+
+	assert.True(matchTriples("ng", []string{"(host.example.com,-,)"}, netgroupQuery{hosts: hosts}))
+	assert.False(matchTriples("ng", []string{"(other.example.com,,)", "(,user,)"}, netgroupQuery{hosts: hosts}))
+
+	triples := []string{"(host.example.com,alice,)", "(host.example.com,,example)", "(host.example.com,-,-)"}
+	assert.True(matchTriples("ng", triples, netgroupQuery{hosts: hosts, user: "alice"}))
+	assert.True(matchTriples("ng", triples, netgroupQuery{hosts: hosts, user: "bob", domain: "example"}))
+	assert.False(matchTriples("ng", triples, netgroupQuery{hosts: hosts, user: "bob", domain: "other"}))
+	assert.False(matchTriples("ng", triples[2:], netgroupQuery{hosts: hosts, user: "alice"}))
+	assert.True(matchTriples("ng", triples[2:], netgroupQuery{hosts: hosts}))
+	assert.False(matchTriples("ng", []string{"(host.example.com,alice,example)"}, netgroupQuery{hosts: hosts, user: "alice", domain: "other"}))
+
+	assert.True(matchTriples("ng", []string{"(Host.Example.COM,,)"}, netgroupQuery{hosts: hosts}))
+
+	wildcards := []string{"(,alice,)", "(,,)", "(-,bob,)"}
+	for _, mode := range []string{"", wildcardDeny} {
+		cfg.NetgroupWildcard = mode
+		assert.False(matchTriples("ng", wildcards, netgroupQuery{hosts: hosts, login: "alice"}))
+	}
+
+	// nss backend keeps innetgr semantics unless wildcard host mode is configured
+	cfg.NetgroupWildcard = ""
+	assert.True(matchTriples("ng", wildcards[1:], netgroupQuery{hosts: hosts, login: "carol", wildcard: wildcardAny}))
+	cfg.NetgroupWildcard = wildcardDeny
+	assert.False(matchTriples("ng", wildcards[1:], netgroupQuery{hosts: hosts, login: "carol", wildcard: wildcardAny}))
+
+	cfg.NetgroupWildcard = wildcardAny
+	assert.True(matchTriples("ng", wildcards[1:], netgroupQuery{hosts: hosts, login: "carol"}))
+	assert.False(matchTriples("ng", wildcards[:1], netgroupQuery{hosts: hosts, user: "carol", login: "carol"}))
+
+	cfg.NetgroupWildcard = wildcardUser
+	assert.True(matchTriples("ng", wildcards, netgroupQuery{hosts: hosts, login: "alice"}))
+	assert.False(matchTriples("ng", wildcards, netgroupQuery{hosts: hosts, login: "bob"}))
+	assert.False(matchTriples("ng", wildcards[1:], netgroupQuery{hosts: hosts, login: "carol"}))
+
+	cfg.LdapServers = []string{"ldap.example.com"}
+	cfg.NetgroupWildcard = "any"
+	assert.EqualError(cfg.Check(), "Invalid netgroup wildcard host mode, need deny, allow-any-host or match-user")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+
+	u "github.com/iavael/goutil"
+)
+
+// OptionPolicy defines which authorized_keys options printed keys must or must not have
+type OptionPolicy struct {
+	Require        []string `yaml:"require"`
+	Forbid         []string `yaml:"forbid"`
+	DenyBroadFrom  bool     `yaml:"deny_broad_from"`
+	MinFromPrefix4 int      `yaml:"min_from_prefix4"`
+	MinFromPrefix6 int      `yaml:"min_from_prefix6"`
+}
+
+// Check function validates option policy
+func (p *OptionPolicy) Check() error {
+	switch {
+	case p.MinFromPrefix4 < 0 || p.MinFromPrefix4 > 32:
+		return errors.New("Minimal IPv4 prefix in from option must be between 0 and 32")
+	case p.MinFromPrefix6 < 0 || p.MinFromPrefix6 > 128:
+		return errors.New("Minimal IPv6 prefix in from option must be between 0 and 128")
+	}
+	for _, opt := range p.Require {
+		if u.MemberOfSlice(strings.ToLower(opt), p.lowerForbid()) {
+			return errors.New(strCat("Option ", opt, " is both required and forbidden"))
+		}
+	}
+	return nil
+}
+
+func (p *OptionPolicy) lowerForbid() (res []string) {
+	for _, opt := range p.Forbid {
+		res = append(res, strings.ToLower(opt))
+	}
+	return
+}
+
+func (p *OptionPolicy) enabled() bool {
+	return len(p.Require) != 0 || len(p.Forbid) != 0 || p.DenyBroadFrom
+}
+
+// checkOptions returns reason why key options violate policy or nil if they're acceptable
+func (p *OptionPolicy) checkOptions(opts []string) error {
+	names := map[string]bool{}
+	for _, opt := range opts {
+		name := optionName(opt)
+		names[name] = true
+		if u.MemberOfSlice(name, p.lowerForbid()) {
+			return errors.New(strCat("option ", name, " is forbidden"))
+		}
+		if name == "from" && p.DenyBroadFrom {
+			if err := p.checkFrom(optionValue(opt)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, req := range p.Require {
+		if !names[strings.ToLower(req)] {
+			return errors.New(strCat("required option ", req, " is missing"))
+		}
+	}
+	return nil
+}
+
+// checkFrom rejects from= pattern lists which allow (almost) any host
+func (p *OptionPolicy) checkFrom(patterns string) error {
+	for _, pattern := range strings.Split(patterns, ",") {
+		if strings.HasPrefix(pattern, "!") {
+			continue
+		}
+		if len(strings.Trim(pattern, "*?.:")) == 0 {
+			return errors.New(strCat("from pattern ", pattern, " matches any host"))
+		}
+		if _, network, err := net.ParseCIDR(pattern); err == nil {
+			ones, bits := network.Mask.Size()
+			minPrefix := p.MinFromPrefix4
+			if bits == 128 {
+				minPrefix = p.MinFromPrefix6
+			}
+			if ones == 0 || ones < minPrefix {
+				return errors.New(strCat("from network ", pattern, " is too broad, need prefix at least /", strconv.Itoa(minPrefix)))
+			}
+		}
+	}
+	return nil
+}
+
+// optionValue returns unquoted value of option
+func optionValue(opt string) string {
+	i := strings.IndexByte(opt, '=')
+	if i < 0 {
+		return ""
+	}
+	value := opt[i+1:]
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		value = value[1 : len(value)-1]
+	}
+	return value
+}
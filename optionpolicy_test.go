@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestOptionPolicy(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		policy = &cfg.OptionPolicy
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+
+	policy.Require = []string{"from"}
+	policy.Forbid = []string{"permitopen", "agent-forwarding"}
+	policy.DenyBroadFrom = true
+	policy.MinFromPrefix4 = 16
+	assert.NoError(policy.Check())
+
+	assert.NoError(policy.checkOptions([]string{`from="10.1.0.0/16,!10.1.1.1"`, "no-pty"}))
+	assert.NoError(policy.checkOptions([]string{`from="*.example.com"`}))
+	assert.Error(policy.checkOptions([]string{"no-pty"}))
+	assert.Error(policy.checkOptions([]string{`from="10.0.0.0/8"`}))
+	assert.Error(policy.checkOptions([]string{`from="0.0.0.0/0"`}))
+	assert.Error(policy.checkOptions([]string{`from="::/0"`}))
+	assert.Error(policy.checkOptions([]string{`from="host.example.com,*"`}))
+	assert.Error(policy.checkOptions([]string{`from="10.1.0.0/16"`, `permitopen="localhost:80"`}))
+	assert.Error(policy.checkOptions([]string{"restrict", `from="10.1.0.0/16"`, "Agent-Forwarding"}))
+
+	pub, _, _ := ed25519.GenerateKey(rand.Reader)
+	key, _ := ssh.NewPublicKey(pub)
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	keys := newUserKeys("uid=user", []string{
+		line,
+		strCat(`from="10.1.2.0/24" `, line),
+		strCat(`from="10.1.2.0/24" `, line, "\n", line),
+	}, nil)
+	assert.Equal(keys[1:2], filterOptions(keys))
+
+	policy.Forbid = append(policy.Forbid, "from")
+	assert.Error(policy.Check())
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	osuser "os/user"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+// LocalOverrides describes local files which take precedence over LDAP
+type LocalOverrides struct {
+	EmergencyKeys string `yaml:"emergency_keys"`
+	AllowFile     string `yaml:"allow_file"`
+	DenyFile      string `yaml:"deny_file"`
+}
+
+// accessList holds usernames and @groups from local allow or deny file
+type accessList struct {
+	path   string
+	users  map[string]bool
+	groups map[string]bool
+}
+
+var localAllow, localDeny *accessList
+
+// loadAccessLists reads configured allow and deny files, missing file is treated as empty
+func loadAccessLists() (err error) {
+	overrides := config.GetLocalOverrides()
+	if localAllow, err = loadAccessList(overrides.AllowFile); err != nil {
+		return
+	}
+	localDeny, err = loadAccessList(overrides.DenyFile)
+	return
+}
+
+func loadAccessList(path string) (*accessList, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		debugLog("Local access list %s does not exist", path)
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return parseAccessList(path, file)
+}
+
+// parseAccessList reads one username or @group per line, # starts comment
+func parseAccessList(path string, r io.Reader) (*accessList, error) {
+	al := &accessList{path: path, users: map[string]bool{}, groups: map[string]bool{}}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case len(line) == 0:
+		case strings.HasPrefix(line, "@"):
+			al.groups[line[1:]] = true
+		default:
+			al.users[line] = true
+		}
+	}
+	return al, scanner.Err()
+}
+
+func (al *accessList) hasGroups() bool {
+	return al != nil && len(al.groups) != 0
+}
+
+// match returns list entry matching user or one of their groups
+func (al *accessList) match(user string, groups []string) (string, bool) {
+	if al == nil {
+		return "", false
+	}
+	if al.users[user] {
+		return user, true
+	}
+	for _, group := range groups {
+		if al.groups[group] {
+			return strCat("@", group), true
+		}
+	}
+	return "", false
+}
+
+// localAccess checks user against local allow and deny lists, deny wins, groups are taken from dir
+func localAccess(ctx context.Context, dir Directory, user string) (allowed, denied bool) {
+	var groups []string
+	if localAllow.hasGroups() || localDeny.hasGroups() {
+		groups = groupNames(memberGroups(ctx, dir, user))
+	}
+	if entry, ok := localDeny.match(user, groups); ok {
+		logger.Warn("Access denied to user %s by %s in local deny list %s", user, entry, localDeny.path)
+		return false, true
+	}
+	if entry, ok := localAllow.match(user, groups); ok {
+		logger.Warn("Access granted to user %s by %s in local allow list %s", user, entry, localAllow.path)
+		return true, false
+	}
+	return false, false
+}
+
+// localGroups returns names of user's groups from NSS, directory can't be asked when it has failed
+func localGroups(user string) (groups []string, err error) {
+	account, err := osuser.Lookup(user)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := account.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if group, err := osuser.LookupGroupId(id); err != nil {
+			debugLog("Failed to look up group %s: %s", id, err)
+		} else {
+			groups = append(groups, group.Name)
+		}
+	}
+	return groups, nil
+}
+
+// emergencyKeys returns keys of user from local emergency keys file, it's used only when directory
+// can't be reached. Each line of file is username followed by authorized_keys line, keys pass through
+// key policy and get options of group templates like keys from directory, groups are resolved via NSS
+func emergencyKeys(path, user string) (keys []userKey) {
+	if len(path) == 0 {
+		return nil
+	}
+	var (
+		groups  []string
+		options = config.GetKeyOptions()
+		policy  = config.GetKeyPolicy()
+	)
+	if localDeny.hasGroups() || len(options.Groups) != 0 || len(policy.RequireSKGroups) != 0 {
+		var err error
+		if groups, err = localGroups(user); err != nil {
+			logger.Warn("Can't resolve local groups of user %s, @group entries of local deny list %s and group policies are not applied to emergency keys: %s",
+				user, localDeny.path, err)
+		}
+	}
+	if entry, ok := localDeny.match(user, groups); ok {
+		logger.Warn("Emergency keys of user %s skipped by %s in local deny list %s", user, entry, localDeny.path)
+		return nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		logger.Error("Failed to read emergency keys: %s", err)
+		return nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.IndexAny(line, " \t")
+		if i < 0 || line[:i] != user {
+			continue
+		}
+		keys = append(keys, userKey{
+			line:   strings.TrimSpace(line[i:]),
+			dn:     path,
+			reason: "emergency keys file",
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		logger.Error("Failed to read emergency keys: %s", err)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	logger.Warn("Directory is unreachable, using %d emergency keys of user %s from %s", len(keys), user, path)
+
+	var entries []*ldap.Entry
+	for _, group := range groups {
+		entries = append(entries, ldap.NewEntry(strCat("cn=", group), map[string][]string{"cn": {group}}))
+	}
+	keys = filterKeys(keys, policy.needSK(groups))
+	return injectOptions(keys, options.groupOptions(entries))
+}
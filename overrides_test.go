@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	osuser "os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"gopkg.in/ldap.v2"
+)
+
+func TestLocalOverrides(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		err    error
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	conn := &ClientTest{search: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		return &ldap.SearchResult{Entries: []*ldap.Entry{
+			ldap.NewEntry("cn=contractors,ou=groups", map[string][]string{"cn": {"contractors"}}),
+		}}, nil
+	}}
+	ldapDir, err := newDirectory(cfg, conn)
+	assert.NoError(err)
+	defer func() { localAllow, localDeny = nil, nil }()
+
+	localAllow, err = parseAccessList("allow", strings.NewReader("# admins\nalice\n@oncall  # pager duty\n"))
+	assert.NoError(err)
+	localDeny, err = parseAccessList("deny", strings.NewReader("mallory\n@contractors\n"))
+	assert.NoError(err)
+
+	allowed, denied := localAccess(context.Background(), ldapDir, "alice")
+	assert.False(allowed)
+	assert.True(denied)
+
+	localDeny.groups = map[string]bool{}
+	allowed, denied = localAccess(context.Background(), ldapDir, "alice")
+	assert.True(allowed)
+	assert.False(denied)
+
+	allowed, denied = localAccess(context.Background(), ldapDir, "bob")
+	assert.False(allowed)
+	assert.False(denied)
+
+	dir, err := ioutil.TempDir("", "keyreader")
+	assert.NoError(err)
+	defer os.RemoveAll(dir)
+
+	cfg.LocalOverrides.AllowFile = filepath.Join(dir, "allow")
+	assert.NoError(loadAccessLists())
+	assert.Nil(localAllow)
+	localDeny, _ = parseAccessList("deny", strings.NewReader("mallory\n"))
+
+	cfg.LocalOverrides.EmergencyKeys = filepath.Join(dir, "emergency_keys")
+	assert.NoError(ioutil.WriteFile(cfg.LocalOverrides.EmergencyKeys, []byte(
+		"# break glass\nalice ssh-ed25519 AAAA alice@vault\nbob\tssh-ed25519 BBBB\nmallory ssh-ed25519 CCCC\n",
+	), 0600))
+	assert.Equal([]userKey{
+		{line: "ssh-ed25519 AAAA alice@vault", dn: cfg.LocalOverrides.EmergencyKeys, reason: "emergency keys file"},
+	}, emergencyKeys(cfg.LocalOverrides.EmergencyKeys, "alice"))
+	assert.Len(emergencyKeys(cfg.LocalOverrides.EmergencyKeys, "bob"), 1)
+	assert.Empty(emergencyKeys(cfg.LocalOverrides.EmergencyKeys, "mallory"))
+	assert.Empty(emergencyKeys(cfg.LocalOverrides.EmergencyKeys, "carol"))
+
+	// @group entries are resolved via NSS since directory has failed
+	if current, err := osuser.Current(); err == nil {
+		if groups, err := localGroups(current.Username); err == nil && len(groups) != 0 {
+			assert.NoError(ioutil.WriteFile(cfg.LocalOverrides.EmergencyKeys, []byte(
+				strCat(current.Username, " ssh-ed25519 DDDD\n"),
+			), 0600))
+			assert.Len(emergencyKeys(cfg.LocalOverrides.EmergencyKeys, current.Username), 1)
+			localDeny, _ = parseAccessList("deny", strings.NewReader(strCat("@", groups[0], "\n")))
+			assert.Empty(emergencyKeys(cfg.LocalOverrides.EmergencyKeys, current.Username))
+		}
+	}
+	// Emergency keys pass through key policy and group option templates
+	if current, err := osuser.Current(); err == nil {
+		if groups, err := localGroups(current.Username); err == nil && len(groups) != 0 {
+			localDeny = nil
+			priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+			pub, _ := ssh.NewPublicKey(&priv.PublicKey)
+			ecdsaLine := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+			assert.NoError(ioutil.WriteFile(cfg.LocalOverrides.EmergencyKeys, []byte(strCat(
+				current.Username, " ", dirTestKey, " vault\n",
+				current.Username, " ", ecdsaLine, " ecdsa\n",
+			)), 0600))
+			cfg.KeyPolicy.DenyTypes = []string{ssh.KeyAlgoECDSA256}
+			cfg.KeyOptions.Groups = map[string]string{groups[0]: "no-pty"}
+			keys := emergencyKeys(cfg.LocalOverrides.EmergencyKeys, current.Username)
+			if assert.Len(keys, 1) {
+				assert.Equal(strCat("no-pty ", dirTestKey, " vault"), keys[0].line)
+			}
+			cfg.KeyPolicy.DenyTypes, cfg.KeyOptions.Groups = nil, nil
+		}
+	}
+	localDeny, _ = parseAccessList("deny", strings.NewReader("@contractors\n"))
+	_, err = localGroups("no-such-user-keyreader")
+	assert.Error(err)
+	assert.Empty(emergencyKeys(cfg.LocalOverrides.EmergencyKeys, "no-such-user-keyreader"))
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"gopkg.in/ldap.v2"
+)
+
+const (
+	refBindTrusted   = "trusted"
+	refBindReuse     = "reuse"
+	refBindAnonymous = "anonymous"
+)
+
+// chaseReferrals follows search continuation references of sr and merges entries found by them
+func (s *ldapSource) chaseReferrals(ctx context.Context, req *ldap.SearchRequest, sr *ldap.SearchResult, hop int, visited map[string]bool) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{
+		Entries:  sr.Entries,
+		Controls: sr.Controls,
+	}
+
+	for _, ref := range sr.Referrals {
+		if hop > s.cfg.GetLdapReferralHops() {
+			return nil, errors.New(strCat("Referral hop limit exceeded on ", ref))
+		}
+		if visited[ref] {
+			logger.Warn("Detected loop on referral %s", ref)
+			continue
+		}
+		visited[ref] = true
+
+		refReq, server, err := referralRequest(req, ref)
+		if err != nil {
+			return nil, err
+		}
+		logger.Info("Following referral %s (hop %d)", ref, hop)
+
+		bind, pass := referralCreds(s.cfg, server)
+		conn, code := dialLdapAs(ctx, s.cfg, server, bind, pass)
+		if code != 0 {
+			return nil, errors.New(strCat("Failed to connect to referral server ", server))
+		}
+		refSr, err := searchConn(ctx, conn, refReq)
+		conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(refSr.Referrals) > 0 {
+			if refSr, err = s.chaseReferrals(ctx, refReq, refSr, hop+1, visited); err != nil {
+				return nil, err
+			}
+		}
+		result.Entries = append(result.Entries, refSr.Entries...)
+	}
+	return result, nil
+}
+
+// referralRequest parses LDAP URL of referral (RFC 4516) and makes search request to follow it
+func referralRequest(req *ldap.SearchRequest, ref string) (*ldap.SearchRequest, string, error) {
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(refURL.Host) == 0 {
+		return nil, "", errors.New(strCat("No host in referral URL ", ref))
+	}
+
+	server := refURL.Host
+	switch refURL.Scheme {
+	case "ldap":
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "389")
+		}
+	case "ldaps":
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "636")
+		}
+		server = strCat("ldaps://", server)
+	default:
+		return nil, "", errors.New(strCat("Unsupported referral URL ", ref))
+	}
+
+	refReq := *req
+	if dn := strings.TrimPrefix(refURL.Path, "/"); len(dn) != 0 {
+		refReq.BaseDN = dn
+	}
+
+	// Query is attributes?scope?filter, attributes are always taken from original request
+	query := strings.Split(refURL.RawQuery, "?")
+	if len(query) > 1 {
+		switch query[1] {
+		case "":
+		case "base":
+			refReq.Scope = ldap.ScopeBaseObject
+		case "one":
+			refReq.Scope = ldap.ScopeSingleLevel
+		case "sub":
+			refReq.Scope = ldap.ScopeWholeSubtree
+		default:
+			return nil, "", errors.New(strCat("Invalid scope in referral URL ", ref))
+		}
+	}
+	if len(query) > 2 && len(query[2]) != 0 {
+		if filter, err := url.QueryUnescape(query[2]); err != nil {
+			return nil, "", err
+		} else {
+			refReq.Filter = filter
+		}
+	}
+	return &refReq, server, nil
+}
+
+// referralCreds decides whether bind credentials of cfg may be sent to referral server
+func referralCreds(cfg Config, server string) (string, string) {
+	switch cfg.GetLdapReferralBind() {
+	case refBindReuse:
+		return cfg.GetLdapBind(), cfg.GetLdapPass()
+	case refBindAnonymous:
+		return "", ""
+	}
+
+	addr, _ := parseServer(server)
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	if domain := cfg.GetLdapDomain(); len(domain) != 0 && strings.HasSuffix(host, strCat(".", domain)) {
+		return cfg.GetLdapBind(), cfg.GetLdapPass()
+	}
+	for _, known := range cfg.GetLdapServers() {
+		knownHost, _ := parseServer(known)
+		if h, _, err := net.SplitHostPort(knownHost); err == nil {
+			knownHost = h
+		}
+		if knownHost == host {
+			return cfg.GetLdapBind(), cfg.GetLdapPass()
+		}
+	}
+	debugLog("Referral server %s is not trusted, binding anonymously", server)
+	return "", ""
+}
+
+func refBindValid(mode string) bool {
+	switch mode {
+	case refBindTrusted, refBindReuse, refBindAnonymous:
+		return true
+	}
+	return false
+}
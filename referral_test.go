@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+func TestReferral(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+		req    = ldap.NewSearchRequest(
+			"dc=example,dc=com",
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			"(cn=netgroup)", []string{"cn"}, nil,
+		)
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapServers = []string{"ldap1.example.com:389"}
+	cfg.LdapDomain = "corp.example.com"
+	cfg.LdapBind = "cn=user,dc=example,dc=com"
+	cfg.LdapPass = "secret"
+
+	refReq, server, err := referralRequest(req, "ldap://ldap2.example.net/ou=netgroups,dc=example,dc=net??one?(cn=other)")
+	assert.NoError(err)
+	assert.Equal("ldap2.example.net:389", server)
+	assert.Equal("ou=netgroups,dc=example,dc=net", refReq.BaseDN)
+	assert.Equal(ldap.ScopeSingleLevel, refReq.Scope)
+	assert.Equal("(cn=other)", refReq.Filter)
+	assert.Equal("dc=example,dc=com", req.BaseDN)
+
+	refReq, server, err = referralRequest(req, "ldaps://ldap2.example.net:1636/ou=netgroups,dc=example,dc=net")
+	assert.NoError(err)
+	assert.Equal("ldaps://ldap2.example.net:1636", server)
+	assert.Equal(ldap.ScopeWholeSubtree, refReq.Scope)
+	assert.Equal("(cn=netgroup)", refReq.Filter)
+
+	_, _, err = referralRequest(req, "http://ldap2.example.net/")
+	assert.Error(err)
+
+	cfg.LdapReferralHops = 1
+	visited := map[string]bool{}
+	_, err = newLdapSource(cfg, nil).chaseReferrals(context.Background(), req, &ldap.SearchResult{Referrals: []string{"ldap://ldap2.example.net/"}}, 2, visited)
+	assert.EqualError(err, "Referral hop limit exceeded on ldap://ldap2.example.net/")
+	assert.Empty(visited)
+
+	cfg.LdapReferralBind = refBindTrusted
+	bind, _ := referralCreds(cfg, "ldap1.example.com:636")
+	assert.Equal(cfg.LdapBind, bind)
+	bind, _ = referralCreds(cfg, "ldaps://dc1.corp.example.com:636")
+	assert.Equal(cfg.LdapBind, bind)
+	bind, _ = referralCreds(cfg, "ldap.example.net:389")
+	assert.Empty(bind)
+
+	cfg.LdapReferralBind = refBindReuse
+	bind, _ = referralCreds(cfg, "ldap.example.net:389")
+	assert.Equal(cfg.LdapBind, bind)
+
+	cfg.LdapReferralBind = refBindAnonymous
+	bind, _ = referralCreds(cfg, "ldap1.example.com:389")
+	assert.Empty(bind)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+
+	"gopkg.in/ldap.v2"
+)
+
+// RoleAccounts maps shared accounts (deploy, root etc.) to LDAP users and groups allowed to use them
+type RoleAccounts struct {
+	Attribute string                 `yaml:"attribute"`
+	Accounts  map[string]RoleAccount `yaml:"accounts"`
+}
+
+// RoleAccount lists users and posix groups allowed to login as role account
+type RoleAccount struct {
+	Users  []string `yaml:"users"`
+	Groups []string `yaml:"groups"`
+}
+
+// Check function validates role accounts settings
+func (r *RoleAccounts) Check() error {
+	for account, role := range r.Accounts {
+		switch {
+		case len(account) == 0:
+			return errors.New("Empty role account name")
+		case len(role.Users) == 0 && len(role.Groups) == 0 && len(r.Attribute) == 0:
+			return errors.New(strCat("No users or groups for role account ", account))
+		}
+	}
+	return nil
+}
+
+// roleMembers returns sorted uids of users allowed to login as account,
+// empty result means account is not a role account. Only accounts declared in config
+// are role accounts, attribute can't turn arbitrary user into one. Attribute and groups are searched in dir
+func roleMembers(ctx context.Context, dir Directory, account string) []string {
+	roles := config.GetRoleAccounts()
+	role, configured := roles.Accounts[account]
+	if !configured {
+		return nil
+	}
+
+	var (
+		members    = append([]string(nil), role.Users...)
+		src        = dirSource(dir)
+		grpFilters []string
+	)
+	for _, group := range role.Groups {
+		grpFilters = append(grpFilters, strCat("(cn=", ldap.EscapeFilter(group), ")"))
+	}
+
+	if len(roles.Attribute) != 0 {
+		roleFilter := strCat("(", roles.Attribute, "=", ldap.EscapeFilter(account), ")")
+		usrReq := ldap.NewSearchRequest(
+			src.cfg.GetLdapUsers(),
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			strCat("(&(objectclass=posixAccount)", roleFilter, ")"),
+			[]string{"uid"},
+			nil,
+		)
+		sr, err := src.ldapSearch(ctx, usrReq)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(27)
+		}
+		for _, entry := range sr.Entries {
+			members = append(members, entry.GetAttributeValues("uid")...)
+		}
+		grpFilters = append(grpFilters, roleFilter)
+	}
+
+	if len(grpFilters) != 0 {
+		grpReq := ldap.NewSearchRequest(
+			src.cfg.GetLdapGroups(),
+			ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 0, 0, false,
+			strCat("(&(objectclass=posixGroup)(|", strCat(grpFilters...), "))"),
+			[]string{"memberUid"},
+			nil,
+		)
+		sr, err := src.ldapSearch(ctx, grpReq)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(27)
+		}
+		for _, entry := range sr.Entries {
+			members = append(members, entry.GetAttributeValues("memberUid")...)
+		}
+	}
+
+	sort.Strings(members)
+	var res []string
+	for i, member := range members {
+		if len(member) == 0 || (i > 0 && members[i-1] == member) {
+			continue
+		}
+		res = append(res, member)
+	}
+	if len(res) == 0 {
+		logger.Warn("Role account %s has no members", account)
+	}
+	return res
+}
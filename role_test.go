@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ldap.v2"
+)
+
+func TestRoleMembers(t *testing.T) {
+	var (
+		assert = assert.New(t)
+		cfg    = &ConfigV3{}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+	config = cfg
+	cfg.LdapUsers = "ou=users"
+	cfg.LdapGroups = "ou=groups"
+	conn := &ClientTest{search: func(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+		switch req.BaseDN {
+		case "ou=users":
+			assert.Equal("(&(objectclass=posixAccount)(accessAs=deploy))", req.Filter)
+			return &ldap.SearchResult{Entries: []*ldap.Entry{
+				ldap.NewEntry("uid=carol,ou=users", map[string][]string{"uid": {"carol"}}),
+			}}, nil
+		case "ou=groups":
+			assert.Equal("(&(objectclass=posixGroup)(|(cn=devops)(accessAs=deploy)))", req.Filter)
+			return &ldap.SearchResult{Entries: []*ldap.Entry{
+				ldap.NewEntry("cn=devops,ou=groups", map[string][]string{"memberUid": {"bob", "alice"}}),
+			}}, nil
+		}
+		t.Fatalf("Unexpected search in %s", req.BaseDN)
+		return nil, nil
+	}}
+	dir, err := newDirectory(cfg, conn)
+	assert.NoError(err)
+
+	assert.Nil(roleMembers(context.Background(), dir, "deploy"))
+
+	cfg.RoleAccounts = RoleAccounts{
+		Attribute: "accessAs",
+		Accounts: map[string]RoleAccount{
+			"deploy": {Users: []string{"alice"}, Groups: []string{"devops"}},
+		},
+	}
+	assert.NoError(cfg.RoleAccounts.Check())
+	assert.Equal([]string{"alice", "bob", "carol"}, roleMembers(context.Background(), dir, "deploy"))
+	// Attribute is searched for declared role accounts only
+	assert.Nil(roleMembers(context.Background(), dir, "alice"))
+
+	keys := []userKey{
+		{line: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ laptop", dn: "uid=bob,ou=users", owner: "bob"},
+		{line: "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ", dn: "uid=user,ou=users"},
+	}
+	assert.Equal("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ laptop keyreader: owner=bob",
+		annotateOwners(keys)[0].line)
+	assert.Equal(keys[1], annotateOwners(keys)[1])
+	assert.Equal("ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIGoEMWLQ+oU8A9dHMwXfX8Ds2w3E4dY7BDPQBuCpI7DJ laptop keyreader: owner=bob dn=uid=bob,ou=users",
+		annotateKeys(keys)[0].line)
+
+	cfg.RoleAccounts.Accounts["root"] = RoleAccount{}
+	assert.NoError(cfg.RoleAccounts.Check())
+	cfg.RoleAccounts.Attribute = ""
+	assert.Error(cfg.RoleAccounts.Check())
+}
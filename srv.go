@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srvResolver is implemented by net.Resolver and by stubs in tests
+type srvResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// discoverServers looks up LDAP servers of domain in DNS,
+// LDAPS servers go first as they don't depend on StartTLS
+func discoverServers(ctx context.Context, resolver srvResolver, domain string) (servers []string) {
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for _, service := range []string{"ldaps", "ldap"} {
+		_, records, err := resolver.LookupSRV(ctx, service, "tcp", domain)
+		if err != nil {
+			debugLog("SRV lookup of _%s._tcp.%s failed: %s", service, domain, err)
+			continue
+		}
+		for _, srv := range orderSRV(records, rnd) {
+			server := strCat(service, "://", net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port))))
+			debugLog("Discovered LDAP server %s", server)
+			servers = append(servers, server)
+		}
+	}
+	return
+}
+
+// orderSRV sorts records by priority and randomizes them by weight within each priority as described in RFC 2782
+func orderSRV(records []*net.SRV, rnd *rand.Rand) []*net.SRV {
+	var ordered []*net.SRV
+
+	sorted := make([]*net.SRV, 0, len(records))
+	for _, srv := range records {
+		// "." target means service is decidedly not available
+		if srv.Target == "." || srv.Target == "" {
+			continue
+		}
+		sorted = append(sorted, srv)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Priority < sorted[j].Priority
+	})
+
+	for start := 0; start < len(sorted); {
+		end := start
+		for end < len(sorted) && sorted[end].Priority == sorted[start].Priority {
+			end++
+		}
+		ordered = append(ordered, byWeight(sorted[start:end], rnd)...)
+		start = end
+	}
+	return ordered
+}
+
+func byWeight(group []*net.SRV, rnd *rand.Rand) []*net.SRV {
+	var (
+		pending = append([]*net.SRV(nil), group...)
+		ordered = make([]*net.SRV, 0, len(group))
+	)
+	// Zero weight records have very small chance to be selected first
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Weight == 0 && pending[j].Weight != 0
+	})
+	for len(pending) > 0 {
+		total := 0
+		for _, srv := range pending {
+			total += int(srv.Weight)
+		}
+		choice, pick := rnd.Intn(total+1), 0
+		for i, srv := range pending {
+			if choice -= int(srv.Weight); choice <= 0 {
+				pick = i
+				break
+			}
+		}
+		ordered = append(ordered, pending[pick])
+		pending = append(pending[:pick], pending[pick+1:]...)
+	}
+	return ordered
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net"
+	"testing"
+
+	u "github.com/iavael/goutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type ResolverTest map[string][]*net.SRV
+
+func (rt ResolverTest) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	cname := strCat("_", service, "._", proto, ".", name)
+	if records, ok := rt[cname]; ok {
+		return cname, records, nil
+	}
+	return "", nil, errors.New("no such host")
+}
+
+func TestSRV(t *testing.T) {
+	var (
+		assert   = assert.New(t)
+		resolver = ResolverTest{
+			"_ldap._tcp.example.com": {
+				{Target: "ldap3.example.com.", Port: 389, Priority: 20, Weight: 0},
+				{Target: "ldap1.example.com.", Port: 389, Priority: 10, Weight: 100},
+				{Target: ".", Port: 389, Priority: 0, Weight: 0},
+			},
+			"_ldaps._tcp.example.com": {
+				{Target: "ldap1.example.com.", Port: 636, Priority: 10, Weight: 0},
+			},
+		}
+	)
+
+	logger = u.NewLogger(u.FATAL, nil)
+
+	assert.Equal([]string{
+		"ldaps://ldap1.example.com:636",
+		"ldap://ldap1.example.com:389",
+		"ldap://ldap3.example.com:389",
+	}, discoverServers(context.Background(), resolver, "example.com"))
+	assert.Empty(discoverServers(context.Background(), resolver, "example.net"))
+
+	rnd := rand.New(rand.NewSource(1))
+	records := []*net.SRV{
+		{Target: "a", Priority: 1, Weight: 0},
+		{Target: "b", Priority: 1, Weight: 1000},
+		{Target: "c", Priority: 0, Weight: 5},
+	}
+	for i := 0; i < 10; i++ {
+		ordered := orderSRV(records, rnd)
+		assert.Len(ordered, 3)
+		assert.Equal("c", ordered[0].Target)
+		assert.Equal(uint16(1), ordered[2].Priority)
+	}
+
+	addr, implicitTLS := parseServer("ldaps://ldap1.example.com:636")
+	assert.Equal("ldap1.example.com:636", addr)
+	assert.True(implicitTLS)
+	addr, implicitTLS = parseServer("ldap1.example.com:389")
+	assert.Equal("ldap1.example.com:389", addr)
+	assert.False(implicitTLS)
+	addr, implicitTLS = parseServer("ldaps://ldap1.example.com")
+	assert.Equal("ldap1.example.com:636", addr)
+	assert.True(implicitTLS)
+	addr, _ = parseServer("ldap://ldap1.example.com/")
+	assert.Equal("ldap1.example.com:389", addr)
+	addr, _ = parseServer("ldap://[2001:db8::1]")
+	assert.Equal("[2001:db8::1]:389", addr)
+	addr, _ = parseServer("ldap1.example.com")
+	assert.Equal("ldap1.example.com:389", addr)
+}